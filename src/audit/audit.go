@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"regexp"
+	"text/tabwriter"
+	"bytes"
+
+	"../parser"
+	"../util"
+)
+
+// A package level var and every function writing to it.
+type Global struct {
+	Package			string
+	Name			string
+	Type			string
+	Exported		bool
+	Mutable			bool
+	Shared			bool
+	Goroutine		bool
+	Writers			[]string
+}
+
+const (
+	// Assignment, op-assignment or inc/dec of a name, its fields or its
+	// elements. "==" and ":=" don't match.
+	WRITE_REGEX = "(^|[^\\w.])%s(\\[.*?\\]|\\.[\\w.]+)*\\s*(,\\s*[\\w.\\[\\]]+\\s*)*(\\+\\+|--|([-+*/%%&|^]|<<|>>|&\\^)?=([^=]|$))"
+	GO_FUNC_REGEX = "\\bgo\\s+func\\s*\\("
+	GOROUTINE_SUFFIX = " (go)"
+)
+
+// Audit every package level var. A var is mutable when anything other than
+// init() writes to it, and shared when it's written from more than one function
+// or from a goroutine.
+func Audit(parse *parser.Parse) []Global {
+	globals := make([]Global, 0)
+	for _, pkg := range parse.Packages {
+		typ := parser.GlobalName(pkg.Name)
+		for _, f := range pkg.Files {
+			t, exists := f.Types[typ]
+			if !exists {
+				continue
+			}
+
+			for _, vars := range []map[string]string{t.PublicVars, t.PrivateVars} {
+				for name, vType := range vars {
+					if _, exists := t.Consts[name]; exists {
+						continue
+					}
+					g := Global{Package: pkg.Name, Name: name, Type: vType}
+					g.Exported = strings.ToUpper(string(name[0])) == string(name[0])
+					g.writers(parse)
+					globals = append(globals, g)
+				}
+			}
+		}
+	}
+
+	sort.Slice(globals, func(i, j int) bool {
+		if globals[i].Package != globals[j].Package {
+			return globals[i].Package < globals[j].Package
+		}
+		return globals[i].Name < globals[j].Name
+	})
+	return globals
+}
+
+func (g *Global) writers(parse *parser.Parse) {
+	writers := make(parser.Set)
+	for _, pkg := range parse.Packages {
+		for _, f := range pkg.Files {
+			names := make([]string, 0)
+			if pkg.Name == g.Package {
+				names = append(names, g.Name)
+			}
+			for k, v := range f.Imports {
+				if v == g.Package {
+					names = append(names, k + "\\." + g.Name)
+				}
+			}
+			if len(names) == 0 {
+				continue
+			}
+
+			re := regexp.MustCompile(fmt.Sprintf(WRITE_REGEX, "(" + strings.Join(names, "|") + ")"))
+			for _, t := range f.Types {
+				for _, funcs := range []map[string]parser.Func{t.PublicFuncs, t.PrivateFuncs} {
+					for _, fn := range funcs {
+						name := fn.Name
+						if t.Type != "global" {
+							name = t.Name + "." + fn.Name
+						}
+						if pkg.Name != g.Package {
+							name = pkg.Name + "." + name
+						}
+
+						if t.Type == "global" && fn.Name == "init" {
+							continue
+						}
+
+						for _, goroutine := range funcWrites(re, fn) {
+							if goroutine || fn.Goroutine {
+								writers[name + GOROUTINE_SUFFIX] = struct{}{}
+								g.Goroutine = true
+							} else {
+								writers[name] = struct{}{}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	g.Writers = make([]string, 0)
+	for w, _ := range writers {
+		g.Writers = append(g.Writers, w)
+	}
+	sort.Strings(g.Writers)
+	g.Mutable = len(g.Writers) != 0
+	g.Shared = len(g.Writers) > 1 || g.Goroutine
+}
+
+// Every write in the body of fn. true for writes inside a "go func() {...}"
+// literal.
+func funcWrites(re *regexp.Regexp, fn parser.Func) []bool {
+	found := make([]bool, 0)
+	goRe := regexp.MustCompile(GO_FUNC_REGEX)
+	depth, goDepth := 0, -1
+	for i, line := range fn.Body {
+		if i == 0 {
+			continue
+		}
+		if goDepth < 0 && goRe.MatchString(line) {
+			goDepth = depth
+		}
+		if re.MatchString(line) {
+			found = append(found, goDepth >= 0)
+		}
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+		if goDepth >= 0 && depth <= goDepth {
+			goDepth = -1
+		}
+	}
+	return found
+}
+
+// Map of "package.Name" to its audit.
+func AuditMap(globals []Global) map[string]Global {
+	m := make(map[string]Global)
+	for _, g := range globals {
+		m[g.Package + "." + g.Name] = g
+	}
+	return m
+}
+
+func Table(globals []Global) string {
+	buffer := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buffer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tVAR\tTYPE\tMUTABLE\tSHARED\tWRITERS")
+	for _, g := range globals {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\t%s\n", g.Package, g.Name, g.Type, g.Mutable, g.Shared, strings.Join(g.Writers, ", "))
+	}
+	w.Flush()
+	return buffer.String()
+}
+
+func PrintAudit(parse *parser.Parse) error {
+	globals := Audit(parse)
+	if util.Format == "json" {
+		data, err := util.Dump(globals)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+	fmt.Print(Table(globals))
+	return nil
+}
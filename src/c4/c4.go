@@ -0,0 +1,92 @@
+package c4
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"../plantuml"
+	"../puml"
+	"../util"
+)
+
+const (
+	INCLUDE = "!include <C4/C4_Component>"
+	CONTAINER = "Container_Boundary(%s, \"%s\") {\n"
+	COMPONENT = "\tComponent(%s, \"%s\", \"Go package\", \"%s\")\n"
+	REL = "Rel(%s, %s, \"Uses\")\n"
+	WEIGHTED_REL = "Rel(%s, %s, \"Uses\", \"%s\")\n"
+)
+
+// Macro ids may only hold letters, digits and underscores, so the collision
+// free PlantUML ids are reused.
+func id(name string) string {
+	return puml.PackageAlias(name)
+}
+
+func moduleID(module string) string {
+	return "module_" + id(module)
+}
+
+// C4 macro arguments are double quoted strings on a single line.
+func text(s string) string {
+	s = strings.Replace(s, "\"", "'", -1)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// First sentence of a package doc comment.
+func Description(doc string) string {
+	doc = text(doc)
+	if i := strings.Index(doc, ". "); i >= 0 {
+		return doc[:i+1]
+	}
+	return doc
+}
+
+// Component diagram with one container per module and one component per
+// package, using the C4-PlantUML stdlib macros.
+func C4String(uml *puml.PlantUML) string {
+	modules := make(map[string][]puml.Namespace)
+	for _, ns := range uml.SortedNamespaces() {
+		modules[ns.Module] = append(modules[ns.Module], ns)
+	}
+
+	names := make([]string, 0)
+	for name, _ := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c4 := "@startuml\n" + INCLUDE + "\n"
+	for _, name := range names {
+		c4 += fmt.Sprintf(CONTAINER, moduleID(name), text(name))
+		for _, ns := range modules[name] {
+			c4 += fmt.Sprintf(COMPONENT, id(ns.Name), text(ns.Name), Description(ns.Doc))
+		}
+		c4 += "}\n"
+	}
+
+	for _, ns := range uml.SortedNamespaces() {
+		deps := make([]string, 0)
+		for dep, _ := range ns.Dependencies {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+
+		for _, dep := range deps {
+			if _, exists := uml.Namespaces[dep]; !exists {
+				continue
+			}
+			if n := ns.Dependencies[dep]; n != 0 {
+				c4 += fmt.Sprintf(WEIGHTED_REL, id(ns.Name), id(dep), util.References(n))
+			} else {
+				c4 += fmt.Sprintf(REL, id(ns.Name), id(dep))
+			}
+		}
+	}
+	return c4 + "@enduml\n"
+}
+
+func RenderC4(uml *puml.PlantUML) error {
+	return plantuml.Output("diagram", strings.TrimSuffix(C4String(uml), "\n"))
+}
@@ -0,0 +1,335 @@
+package callgraph
+
+import (
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"../parser"
+)
+
+// A function or method, named after the class it is drawn on: its receiver
+// type, or <Pkg>Global for package functions.
+type Func struct {
+	Namespace		string
+	Class			string
+	Name			string
+}
+
+// "namespace.Class" key of the class the function is drawn on.
+func (fn Func) ClassKey() string {
+	return fn.Namespace + "." + fn.Class
+}
+
+// "namespace.Class.Func" key, eg. "app.Server.Get".
+func (fn Func) String() string {
+	return fn.ClassKey() + "." + fn.Name
+}
+
+// A call in the body of a function. Calls through an interface have one Call
+// per implementation of the method, marked Dynamic.
+type Call struct {
+	To				Func
+	Pos				parser.Position
+	Dynamic			bool
+}
+
+// Static call graph of the parsed packages, type checked with go/types.
+// Imports from outside the parsed tree are left empty, so only calls between
+// parsed packages are found.
+type Graph struct {
+	Fset			*token.FileSet
+	Info			*types.Info
+	// Declarations by "namespace.Class.Func" key.
+	Decls			map[string]*ast.FuncDecl
+	Funcs			map[string]Func
+	// Calls of each function in source order.
+	Calls			map[string][]Call
+
+	parse			*parser.Parse
+	keys			map[*types.Func]Func
+	packages		map[string]*types.Package
+	named			[]*types.Named
+}
+
+type importer struct {
+	g				*Graph
+}
+
+func (imp importer) Import(path string) (*types.Package, error) {
+	return imp.ImportFrom(path, "", 0)
+}
+
+// Packages of the parsed tree are type checked from source, others are empty.
+func (imp importer) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if name := imp.g.parse.Resolve(path, dir); name != "" {
+		return imp.g.check(name), nil
+	}
+	pkg := types.NewPackage(path, pathBase(path))
+	pkg.MarkComplete()
+	return pkg, nil
+}
+
+func pathBase(p string) string {
+	return p[strings.LastIndex(p, "/")+1:]
+}
+
+// Type check the parsed package name once. Type errors, eg. from the empty
+// imports, are ignored: what can't be resolved isn't a call between parsed
+// packages.
+func (g *Graph) check(name string) *types.Package {
+	if pkg, exists := g.packages[name]; exists {
+		return pkg
+	}
+
+	files := make([]*ast.File, 0)
+	filenames := make([]string, 0)
+	for filename, _ := range g.parse.Packages[name].Files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		f := g.parse.Packages[name].Files[filename]
+		// Files with syntax errors are checked as far as they parse.
+		file, _ := goparser.ParseFile(g.Fset, filename, f.Raw, 0)
+		if file == nil || file.Name == nil {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	pkgName := pathBase(name)
+	if len(files) != 0 {
+		pkgName = files[0].Name.Name
+	}
+	// Placeholder for import cycles, replaced once checked.
+	g.packages[name] = types.NewPackage(name, pkgName)
+
+	config := types.Config{Importer: importer{g}, Error: func(error) {}}
+	pkg, _ := config.Check(name, g.Fset, files, g.Info)
+	g.packages[name] = pkg
+	g.declare(name, pkg, files)
+	return pkg
+}
+
+// Record the functions and named types declared in the files of namespace.
+func (g *Graph) declare(namespace string, pkg *types.Package, files []*ast.File) {
+	global := parser.GlobalName(namespace)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			obj, ok := g.Info.Defs[fd.Name].(*types.Func)
+			if !ok {
+				continue
+			}
+
+			key := Func{Namespace: namespace, Class: global, Name: fd.Name.Name}
+			if fd.Recv != nil && len(fd.Recv.List) != 0 {
+				key.Class = receiver(fd.Recv.List[0].Type)
+			}
+			if key.Class == "" || fd.Name.Name == "_" {
+				continue
+			}
+			g.keys[obj] = key
+			g.Decls[key.String()] = fd
+			g.Funcs[key.String()] = key
+		}
+	}
+
+	if pkg == nil {
+		return
+	}
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		if tn, ok := scope.Lookup(name).(*types.TypeName); ok {
+			if named, ok := tn.Type().(*types.Named); ok && !types.IsInterface(named) && named.TypeParams().Len() == 0 {
+				g.named = append(g.named, named)
+			}
+		}
+	}
+}
+
+// Name of the receiver type, eg. "Cache" for "*Cache[K, V]".
+func receiver(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// Functions the call expression may run. A method called through an interface
+// resolves to the methods of every parsed type implementing the interface.
+func (g *Graph) Callees(call *ast.CallExpr) ([]Func, bool) {
+	fun := call.Fun
+	for {
+		switch e := fun.(type) {
+		case *ast.ParenExpr:
+			fun = e.X
+			continue
+		case *ast.IndexExpr:
+			fun = e.X
+			continue
+		case *ast.IndexListExpr:
+			fun = e.X
+			continue
+		}
+		break
+	}
+
+	var id *ast.Ident
+	switch e := fun.(type) {
+	case *ast.Ident:
+		id = e
+	case *ast.SelectorExpr:
+		id = e.Sel
+	default:
+		return nil, false
+	}
+
+	fn, ok := g.Info.Uses[id].(*types.Func)
+	if !ok {
+		return nil, false
+	}
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil && types.IsInterface(recv.Type()) {
+		return g.implementations(recv.Type(), fn), true
+	}
+	if key, exists := g.keys[fn.Origin()]; exists {
+		return []Func{key}, false
+	}
+	return nil, false
+}
+
+// Methods implementing the interface method fn of the parsed types that
+// implement iface, directly or through a pointer, sorted by key.
+func (g *Graph) implementations(iface types.Type, fn *types.Func) []Func {
+	it, ok := iface.Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+	funcs := make([]Func, 0)
+	for _, named := range g.named {
+		ptr := types.NewPointer(named)
+		if !types.Implements(named, it) && !types.Implements(ptr, it) {
+			continue
+		}
+		// Unexported method names are looked up in the package declaring them.
+		sel := types.NewMethodSet(ptr).Lookup(fn.Pkg(), fn.Name())
+		if sel == nil {
+			continue
+		}
+		if fn, ok := sel.Obj().(*types.Func); ok {
+			if key, exists := g.keys[fn.Origin()]; exists {
+				funcs = append(funcs, key)
+			}
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].String() < funcs[j].String()
+	})
+	return funcs
+}
+
+// Position relative to the src directory, as in the parsed model.
+func (g *Graph) Position(pos token.Pos) parser.Position {
+	p := g.Fset.Position(pos)
+	return parser.Position{File: p.Filename, Line: p.Line}
+}
+
+// Build the call graph of the parsed packages.
+func Build(parse *parser.Parse) *Graph {
+	g := &Graph{
+		Fset: token.NewFileSet(),
+		Info: &types.Info{
+			Defs: make(map[*ast.Ident]types.Object),
+			Uses: make(map[*ast.Ident]types.Object),
+			Types: make(map[ast.Expr]types.TypeAndValue),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		},
+		Decls: make(map[string]*ast.FuncDecl),
+		Funcs: make(map[string]Func),
+		Calls: make(map[string][]Call),
+		parse: parse,
+		keys: make(map[*types.Func]Func),
+		packages: make(map[string]*types.Package),
+	}
+
+	names := make([]string, 0)
+	for name, _ := range parse.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g.check(name)
+	}
+	sort.Slice(g.named, func(i, j int) bool {
+		return g.named[i].Obj().Pkg().Path() + "." + g.named[i].Obj().Name() < g.named[j].Obj().Pkg().Path() + "." + g.named[j].Obj().Name()
+	})
+
+	for key, decl := range g.Decls {
+		if decl.Body == nil {
+			continue
+		}
+		calls := make([]Call, 0)
+		ast.Inspect(decl.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			callees, dynamic := g.Callees(call)
+			for _, to := range callees {
+				calls = append(calls, Call{To: to, Pos: g.Position(call.Pos()), Dynamic: dynamic})
+			}
+			return true
+		})
+		g.Calls[key] = calls
+	}
+	return g
+}
+
+// Record the functions each parsed function calls on its parser.Func, as
+// "namespace.Class.Func" keys.
+func (g *Graph) Annotate(parse *parser.Parse) {
+	for key, calls := range g.Calls {
+		if len(calls) == 0 {
+			continue
+		}
+		from := g.Funcs[key]
+		pkg := parse.Packages[from.Namespace]
+		pos := g.Position(g.Decls[key].Pos())
+		t, exists := pkg.Files[pos.File].Types[from.Class]
+		if !exists {
+			continue
+		}
+		// Funcs declared more than once, eg. init, are told apart by line.
+		for _, funcs := range []map[string]parser.Func{t.PublicFuncs, t.PrivateFuncs} {
+			for k, fn := range funcs {
+				if fn.Name != from.Name || fn.Pos.Line != pos.Line {
+					continue
+				}
+				fn.Calls = make(parser.Set)
+				for _, call := range calls {
+					fn.Calls[call.To.String()] = struct{}{}
+				}
+				funcs[k] = fn
+			}
+		}
+	}
+}
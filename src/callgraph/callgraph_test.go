@@ -0,0 +1,88 @@
+package callgraph
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"../parser"
+)
+
+// Parse the .go files under dir, a path relative to the test with a "src"
+// directory in it.
+func parseDir(t *testing.T, dir string) *parser.Parse {
+	sources := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && filepath.Ext(path) == ".go" {
+			sources = append(sources, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := parser.Parser(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func callKeys(calls []Call) []string {
+	keys := make([]string, 0)
+	for _, call := range calls {
+		keys = append(keys, call.To.String())
+	}
+	return keys
+}
+
+func TestCalls(t *testing.T) {
+	g := Build(parseDir(t, "testdata/calls/src"))
+
+	tests := []struct {
+		from string
+		want []string
+	}{
+		{"app.Server.Run", []string{"app.Server.close", "app.Server.worker", "app.Server.step", "shape.ShapeGlobal.Total", "app.Server.step", "app.Server.step"}},
+		{"app.AppGlobal.Start", []string{"app.Server.Run"}},
+		// Through the interface, to both implementations sorted by key.
+		{"shape.ShapeGlobal.Total", []string{"shape.Circle.Area", "shape.Square.Area"}},
+		{"shape.Square.Area", []string{}},
+	}
+	for _, test := range tests {
+		if got := callKeys(g.Calls[test.from]); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("calls of %s = %q, want %q", test.from, got, test.want)
+		}
+	}
+
+	for _, call := range g.Calls["shape.ShapeGlobal.Total"] {
+		if !call.Dynamic || call.Pos != (parser.Position{File: "shape/shape.go", Line: 26}) {
+			t.Errorf("call of Total to %s = %+v", call.To, call)
+		}
+	}
+	for _, call := range g.Calls["app.Server.Run"] {
+		if call.Dynamic {
+			t.Errorf("static call of Run to %s is dynamic", call.To)
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	p := parseDir(t, "testdata/calls/src")
+	Build(p).Annotate(p)
+
+	total := p.Packages["shape"].Files["shape/shape.go"].Types["ShapeGlobal"].PublicFuncs["Total"]
+	want := parser.Set{"shape.Circle.Area": {}, "shape.Square.Area": {}}
+	if !reflect.DeepEqual(total.Calls, want) {
+		t.Errorf("calls of Total = %v, want %v", total.Calls, want)
+	}
+
+	run := p.Packages["app"].Files["app/app.go"].Types["Server"].PublicFuncs["Run"]
+	if len(run.Calls) != 4 {
+		t.Errorf("calls of Run = %v", run.Calls)
+	}
+	if step := p.Packages["app"].Files["app/app.go"].Types["Server"].PrivateFuncs["step"]; step.Calls != nil {
+		t.Errorf("calls of step = %v, want none", step.Calls)
+	}
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"../shape"
+)
+
+type Server struct {
+	Shapes []shape.Shape
+}
+
+func (s *Server) Run(ch chan int) {
+	defer s.close()
+	go s.worker(ch)
+	for i := 0; i < 3; i++ {
+		s.step(i)
+	}
+	if len(s.Shapes) > 0 {
+		shape.Total(s.Shapes)
+	} else {
+		s.step(0)
+	}
+	select {
+	case v := <-ch:
+		s.step(v)
+	default:
+	}
+}
+
+func (s *Server) step(i int) {
+}
+
+func (s *Server) worker(ch chan int) {
+}
+
+func (s *Server) close() {
+}
+
+func Start() {
+	s := &Server{}
+	s.Run(nil)
+}
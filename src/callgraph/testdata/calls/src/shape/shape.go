@@ -0,0 +1,29 @@
+package shape
+
+type Shape interface {
+	Area() float64
+}
+
+type Square struct {
+	Side float64
+}
+
+func (s Square) Area() float64 {
+	return s.Side * s.Side
+}
+
+type Circle struct {
+	R float64
+}
+
+func (c *Circle) Area() float64 {
+	return 3 * c.R * c.R
+}
+
+func Total(shapes []Shape) float64 {
+	var total float64
+	for _, s := range shapes {
+		total += s.Area()
+	}
+	return total
+}
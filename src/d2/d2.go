@@ -0,0 +1,115 @@
+package d2
+
+import (
+	"fmt"
+	"strings"
+
+	"../parser"
+	"../puml"
+	"../util"
+)
+
+const (
+	ASSOCIATION = "%s -- %s: %s\n"
+	DEPENDENCY = "%s -> %s: %s\n"
+	WEIGHTED_DEPENDENCY = "%s -> %s: %d\n"
+)
+
+// Keys are always quoted as "." separates containers in D2 and Go types are
+// full of characters D2 treats as syntax.
+func quote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+}
+
+// D2 path to "namespace.Class".
+func path(key string) string {
+	i := strings.LastIndex(key, ".")
+	if i < 0 {
+		return quote(key)
+	}
+	return quote(key[:i]) + "." + quote(key[i+1:])
+}
+
+// D2 class methods are "name(params)": "results".
+func method(visibility string, fn parser.Func) string {
+	results := puml.ParamsString(fn.Results)
+	if len(fn.Results) > 1 || (len(fn.Results) == 1 && fn.Results[0].Name != "" && util.Signatures != "short") {
+		results = "(" + results + ")"
+	}
+	if results == "" {
+		results = "void"
+	}
+	return fmt.Sprintf("\t\t%s: %s\n", quote(visibility + fn.Name + "(" + puml.ParamsString(fn.Params) + ")"), quote(results))
+}
+
+func ClassString(c puml.Class) string {
+	d2 := fmt.Sprintf("\t%s: {\n", quote(c.Name))
+	d2 += "\t\tshape: class\n"
+	if len(c.TypeParams) != 0 {
+		d2 += fmt.Sprintf("\t\tlabel: %s\n", quote(c.Name + "[" + puml.ParamsString(c.TypeParams) + "]"))
+	}
+
+	if !c.Collapsed {
+		for _, k := range puml.SortedVars(c.PrivateVars) {
+			d2 += fmt.Sprintf("\t\t%s: %s\n", quote("-" + k), quote(c.PrivateVars[k]))
+		}
+		for _, k := range puml.SortedVars(c.PublicVars) {
+			d2 += fmt.Sprintf("\t\t%s: %s\n", quote("+" + k), quote(c.PublicVars[k]))
+		}
+		for _, fn := range puml.SortedFuncs(c.PrivateFuncs) {
+			d2 += method("-", fn)
+		}
+		for _, fn := range puml.SortedFuncs(c.PublicFuncs) {
+			d2 += method("+", fn)
+		}
+	}
+	return d2 + "\t}\n"
+}
+
+func PackageString(uml *puml.PlantUML) string {
+	var d2 string
+	for _, ns := range uml.SortedNamespaces() {
+		d2 += fmt.Sprintf("%s: {shape: package}\n", quote(ns.Name))
+	}
+
+	for _, ns := range uml.SortedNamespaces() {
+		for _, dep := range uml.SortedDependencies(ns) {
+			if n := ns.Dependencies[dep]; n != 0 {
+				d2 += fmt.Sprintf(WEIGHTED_DEPENDENCY, quote(ns.Name), quote(dep), n)
+			} else {
+				d2 += fmt.Sprintf(DEPENDENCY, quote(ns.Name), quote(dep), "import")
+			}
+		}
+	}
+	return d2
+}
+
+func D2String(uml *puml.PlantUML) string {
+	if util.Level == "package" {
+		return PackageString(uml)
+	}
+
+	var d2 string
+	for _, ns := range uml.SortedNamespaces() {
+		d2 += fmt.Sprintf("%s: {\n", quote(ns.Name))
+		for _, c := range ns.SortedClasses() {
+			d2 += ClassString(c)
+		}
+		d2 += "}\n"
+	}
+
+	for _, e := range uml.Edges() {
+		if e.Kind == puml.ASSOCIATION {
+			d2 += fmt.Sprintf(ASSOCIATION, path(e.From), path(e.To), e.Kind)
+		} else {
+			d2 += fmt.Sprintf(DEPENDENCY, path(e.From), path(e.To), e.Kind)
+		}
+	}
+	return d2
+}
+
+func RenderD2(uml *puml.PlantUML) error {
+	fmt.Print(D2String(uml))
+	return nil
+}
@@ -0,0 +1,145 @@
+package dot
+
+import (
+	"fmt"
+	"html"
+
+	"../puml"
+	"../util"
+)
+
+const (
+	GLOBAL_COLOR = "green"
+	STRUCT_COLOR = "aquamarine"
+	TYPE_COLOR = "#FF7700"
+	TABLE = "<table border=\"0\" cellborder=\"1\" cellspacing=\"0\" cellpadding=\"4\">"
+	HEADER = "<tr><td bgcolor=\"%s\"><b>%s</b><br/>%s</td></tr>"
+	MEMBERS = "<tr><td align=\"left\" balign=\"left\">%s</td></tr>"
+	ASSOCIATION = "\t%s -> %s [dir=none];\n"
+	DEPENDENCY = "\t%s -> %s [arrowhead=vee];\n"
+	CALLS = "\t%s -> %s [arrowhead=vee, style=dashed, label=\"calls\"];\n"
+	WEIGHTED_DEPENDENCY = "\t%s -> %s [arrowhead=vee, label=\"%d\"];\n"
+)
+
+// Graphviz ids are quoted so namespaces with slashes and dots stay valid.
+func id(name string) string {
+	return fmt.Sprintf("%q", name)
+}
+
+func color(c puml.Class) string {
+	switch c.Type {
+	case "global":
+		return GLOBAL_COLOR
+	case "struct":
+		return STRUCT_COLOR
+	default:
+		return TYPE_COLOR
+	}
+}
+
+func members(lines []string) string {
+	var s string
+	for _, line := range lines {
+		s += html.EscapeString(line) + "<br/>"
+	}
+	return fmt.Sprintf(MEMBERS, s)
+}
+
+// A class as an HTML-like table: name, vars then functions.
+func ClassString(namespace string, c puml.Class) string {
+	name := c.Name
+	if len(c.TypeParams) != 0 {
+		name += "[" + puml.ParamsString(c.TypeParams) + "]"
+	}
+
+	label := TABLE
+	label += fmt.Sprintf(HEADER, color(c), html.EscapeString(name), html.EscapeString(namespace))
+	if !c.Collapsed {
+		vars := make([]string, 0)
+		for _, k := range puml.SortedVars(c.PrivateVars) {
+			vars = append(vars, "- " + k + " " + c.PrivateVars[k])
+		}
+		for _, k := range puml.SortedVars(c.PublicVars) {
+			vars = append(vars, "+ " + k + " " + c.PublicVars[k])
+		}
+
+		funcs := make([]string, 0)
+		for _, fn := range puml.SortedFuncs(c.PrivateFuncs) {
+			funcs = append(funcs, "- " + puml.FuncString(fn))
+		}
+		for _, fn := range puml.SortedFuncs(c.PublicFuncs) {
+			funcs = append(funcs, "+ " + puml.FuncString(fn))
+		}
+		label += members(vars) + members(funcs)
+	}
+	label += "</table>"
+
+	return fmt.Sprintf("\t\t%s [label=<%s>];\n", id(namespace + "." + c.Name), label)
+}
+
+func header() string {
+	dot := "digraph globalpuml {\n"
+	dot += fmt.Sprintf("\trankdir=%s;\n", util.Rankdir)
+	if util.Concentrate {
+		dot += "\tconcentrate=true;\n"
+	}
+	return dot
+}
+
+// Cluster names must start with "cluster" for Graphviz to draw them as boxes.
+func cluster(name string) string {
+	return "cluster_" + puml.PackageAlias(name)
+}
+
+func PackageString(uml *puml.PlantUML) string {
+	dot := header()
+	dot += "\tnode [shape=box, style=rounded];\n"
+	for _, ns := range uml.SortedNamespaces() {
+		dot += fmt.Sprintf("\t%s;\n", id(ns.Name))
+	}
+
+	for _, ns := range uml.SortedNamespaces() {
+		for _, dep := range uml.SortedDependencies(ns) {
+			if n := ns.Dependencies[dep]; n != 0 {
+				dot += fmt.Sprintf(WEIGHTED_DEPENDENCY, id(ns.Name), id(dep), n)
+			} else {
+				dot += fmt.Sprintf(DEPENDENCY, id(ns.Name), id(dep))
+			}
+		}
+	}
+	return dot + "}\n"
+}
+
+func DOTString(uml *puml.PlantUML) string {
+	if util.Level == "package" {
+		return PackageString(uml)
+	}
+
+	dot := header()
+	dot += "\tnode [shape=plaintext];\n"
+	for _, ns := range uml.SortedNamespaces() {
+		dot += fmt.Sprintf("\tsubgraph %s {\n", cluster(ns.Name))
+		dot += fmt.Sprintf("\t\tlabel=%q;\n", ns.Name)
+		for _, c := range ns.SortedClasses() {
+			dot += ClassString(ns.Name, c)
+		}
+		dot += "\t}\n"
+	}
+
+	for _, e := range uml.Edges() {
+		switch e.Kind {
+		case puml.ASSOCIATION:
+			dot += fmt.Sprintf(ASSOCIATION, id(e.From), id(e.To))
+		case puml.CALLS:
+			dot += fmt.Sprintf(CALLS, id(e.From), id(e.To))
+		default:
+			dot += fmt.Sprintf(DEPENDENCY, id(e.From), id(e.To))
+		}
+	}
+	return dot + "}\n"
+}
+
+func RenderDOT(uml *puml.PlantUML) error {
+	fmt.Print(DOTString(uml))
+	return nil
+}
@@ -0,0 +1,316 @@
+package explorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"../model"
+	"../puml"
+)
+
+// Placeholder replaced with the JSON model. The page is not a fmt format as
+// CSS and JS are full of "%".
+const MODEL = "/*MODEL*/"
+
+// Single page explorer with everything inline so it works offline, eg. as a
+// CI artifact. The model is read from the application/json script.
+const PAGE = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>GlobalPUML</title>
+<style>
+body { margin: 0; font: 14px sans-serif; color: #222; display: flex; flex-direction: column; height: 100vh; }
+header { display: flex; gap: 12px; align-items: center; padding: 8px 12px; background: #f0f0f0; border-bottom: 1px solid #ccc; }
+header h1 { font-size: 16px; margin: 0 12px 0 0; }
+main { display: flex; flex: 1; min-height: 0; }
+section { overflow: auto; padding: 8px 12px; border-right: 1px solid #ddd; }
+#types { width: 280px; flex: none; }
+#graph { flex: 1; }
+#members { width: 460px; flex: none; border-right: none; }
+ul { list-style: none; margin: 0; padding-left: 16px; }
+#types ul { padding: 0; }
+li { margin: 2px 0; }
+a { color: #0645ad; cursor: pointer; text-decoration: none; }
+a:hover { text-decoration: underline; }
+.selected { background: #ffefa0; }
+.kind { display: inline-block; width: 16px; height: 16px; margin-right: 4px; border-radius: 8px; text-align: center; font-size: 11px; line-height: 16px; color: #fff; background: #888; }
+.kind.global { background: #2e7d32; }
+.kind.struct { background: #1565c0; }
+.kind.interface { background: #6a1b9a; }
+.kind.func { background: #ef6c00; }
+.pkg { color: #777; }
+.toggle { display: inline-block; width: 14px; cursor: pointer; color: #555; }
+.edge { color: #777; font-size: 12px; margin-right: 4px; }
+.doc { white-space: pre-wrap; color: #444; margin: 2px 0 8px 0; }
+.pos { color: #777; font-size: 12px; }
+code { font: 13px monospace; }
+h2 { font-size: 15px; margin: 8px 0; }
+h3 { font-size: 14px; margin: 12px 0 4px 0; }
+</style>
+</head>
+<body>
+<header>
+<h1>GlobalPUML</h1>
+<input id="search" type="search" placeholder="Search types">
+<select id="package"><option value="">All packages</option></select>
+<label><input id="association" type="checkbox" checked> association</label>
+<label><input id="dependency" type="checkbox" checked> dependency</label>
+<label><input id="calls" type="checkbox" checked> calls</label>
+</header>
+<main>
+<section id="types"><ul id="list"></ul></section>
+<section id="graph"><p>Select a type to see its neighbours.</p></section>
+<section id="members"></section>
+</main>
+<script id="model" type="application/json">/*MODEL*/</script>
+<script>
+(function() {
+	var model = JSON.parse(document.getElementById("model").textContent);
+	var types = {};
+	var keys = [];
+	var links = {};
+	var selected = "";
+
+	model.packages.forEach(function(pkg) {
+		var option = document.createElement("option");
+		option.value = option.textContent = pkg.name;
+		document.getElementById("package").appendChild(option);
+		pkg.types.forEach(function(t) {
+			var key = pkg.name + "." + t.name;
+			types[key] = {key: key, pkg: pkg.name, type: t};
+			keys.push(key);
+			links[key] = [];
+		});
+	});
+
+	// Neighbours of both ends, "out" for the type using the other.
+	model.relationships.forEach(function(r) {
+		var association = r.kind == "association";
+		if (links[r.from]) {
+			links[r.from].push({key: r.to, kind: r.kind, dir: association ? "both" : "out"});
+		}
+		if (links[r.to]) {
+			links[r.to].push({key: r.from, kind: r.kind, dir: association ? "both" : "in"});
+		}
+	});
+
+	function el(tag, cls, text) {
+		var e = document.createElement(tag);
+		if (cls) {
+			e.className = cls;
+		}
+		if (text !== undefined) {
+			e.textContent = text;
+		}
+		return e;
+	}
+
+	function kind(t) {
+		return el("span", "kind " + t.kind, t.kind.charAt(0).toUpperCase());
+	}
+
+	function link(key) {
+		var a = el("a", "", key);
+		a.onclick = function() {
+			select(key);
+		};
+		return a;
+	}
+
+	function position(p) {
+		return el("span", "pos", p ? " " + p.file + ":" + p.line : "");
+	}
+
+	function params(ps) {
+		return ps.map(function(p) {
+			return p.name ? p.name + " " + p.type : p.type;
+		}).join(", ");
+	}
+
+	function signature(m) {
+		var s = m.name;
+		if (m.typeParams) {
+			s += "[" + params(m.typeParams) + "]";
+		}
+		s += "(" + params(m.params) + ")";
+		if (m.results.length == 1 && !m.results[0].name) {
+			s += " : " + m.results[0].type;
+		} else if (m.results.length != 0) {
+			s += " : (" + params(m.results) + ")";
+		}
+		return s;
+	}
+
+	function kinds() {
+		return {
+			association: document.getElementById("association").checked,
+			dependency: document.getElementById("dependency").checked,
+			calls: document.getElementById("calls").checked
+		};
+	}
+
+	function neighbours(key) {
+		var shown = kinds();
+		return links[key].filter(function(l) {
+			return shown[l.kind];
+		});
+	}
+
+	function renderList() {
+		var query = document.getElementById("search").value.toLowerCase();
+		var pkg = document.getElementById("package").value;
+		var list = document.getElementById("list");
+		list.textContent = "";
+		keys.forEach(function(key) {
+			var t = types[key];
+			if ((pkg && t.pkg != pkg) || key.toLowerCase().indexOf(query) < 0) {
+				return;
+			}
+			var li = el("li", key == selected ? "selected" : "");
+			li.appendChild(kind(t.type));
+			var a = el("a", "", t.type.name);
+			a.onclick = function() {
+				select(key);
+			};
+			li.appendChild(a);
+			li.appendChild(el("span", "pkg", " " + t.pkg));
+			list.appendChild(li);
+		});
+	}
+
+	// Tree node of key, children are built on first expand. path holds the
+	// keys above so cycles are not expanded again.
+	function node(key, edge, path, open) {
+		var li = el("li");
+		var next = neighbours(key).filter(function(l) {
+			return path.indexOf(l.key) < 0;
+		});
+		var toggle = el("span", "toggle", next.length ? "+" : "");
+		li.appendChild(toggle);
+		if (edge) {
+			var arrow = {both: "↔", out: "→", in: "←"}[edge.dir];
+			li.appendChild(el("span", "edge", arrow + " " + edge.kind));
+		}
+		if (types[key]) {
+			li.appendChild(kind(types[key].type));
+		}
+		li.appendChild(link(key));
+
+		var children = null;
+		toggle.onclick = function() {
+			if (!children) {
+				children = el("ul");
+				next.forEach(function(l) {
+					children.appendChild(node(l.key, l, path.concat([l.key]), false));
+				});
+				li.appendChild(children);
+			} else {
+				children.style.display = children.style.display == "none" ? "" : "none";
+			}
+			toggle.textContent = children.style.display == "none" ? "+" : "-";
+		};
+		if (open && next.length) {
+			toggle.onclick();
+		}
+		return li;
+	}
+
+	function renderGraph() {
+		var graph = document.getElementById("graph");
+		graph.textContent = "";
+		if (!selected) {
+			return;
+		}
+		graph.appendChild(el("h2", "", "Neighbours"));
+		var ul = el("ul");
+		ul.style.paddingLeft = "0";
+		ul.appendChild(node(selected, null, [selected], true));
+		graph.appendChild(ul);
+	}
+
+	function member(ul, text, m) {
+		var li = el("li");
+		li.appendChild(el("code", "", (m.exported ? "+" : "-") + text));
+		li.appendChild(position(m.position));
+		if (m.doc) {
+			li.appendChild(el("div", "doc", m.doc));
+		}
+		ul.appendChild(li);
+	}
+
+	function renderMembers() {
+		var panel = document.getElementById("members");
+		panel.textContent = "";
+		var t = types[selected];
+		if (!t) {
+			return;
+		}
+		var h = el("h2");
+		h.appendChild(kind(t.type));
+		h.appendChild(document.createTextNode(t.key));
+		panel.appendChild(h);
+		if (t.type.underlying) {
+			panel.appendChild(el("code", "", t.type.underlying));
+		}
+		panel.appendChild(position(t.type.position));
+		if (t.type.doc) {
+			panel.appendChild(el("div", "doc", t.type.doc));
+		}
+		if (t.type.collapsed) {
+			panel.appendChild(el("p", "pos", "Members left out of this diagram."));
+		}
+
+		panel.appendChild(el("h3", "", "Fields"));
+		var fields = el("ul");
+		t.type.fields.forEach(function(f) {
+			member(fields, f.name + (f.type ? " : " + f.type : "") + (f.const ? " {const}" : ""), f);
+		});
+		panel.appendChild(fields);
+
+		panel.appendChild(el("h3", "", "Methods"));
+		var methods = el("ul");
+		t.type.methods.forEach(function(m) {
+			member(methods, signature(m) + (m.goroutine ? " {goroutine}" : ""), m);
+		});
+		panel.appendChild(methods);
+	}
+
+	function select(key) {
+		selected = key;
+		renderList();
+		renderGraph();
+		renderMembers();
+	}
+
+	document.getElementById("search").oninput = renderList;
+	document.getElementById("package").onchange = renderList;
+	document.getElementById("association").onchange = renderGraph;
+	document.getElementById("dependency").onchange = renderGraph;
+	document.getElementById("calls").onchange = renderGraph;
+	renderList();
+})();
+</script>
+</body>
+</html>
+`
+
+// The explorer page with the JSON model of uml. json.Marshal escapes "<", ">"
+// and "&" so the model can't close the script element.
+func HTMLString(uml *puml.PlantUML) (string, error) {
+	data, err := json.Marshal(model.Export(uml))
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(PAGE, MODEL, string(data), 1), nil
+}
+
+func RenderHTML(uml *puml.PlantUML) error {
+	page, err := HTMLString(uml)
+	if err != nil {
+		return err
+	}
+	fmt.Print(page)
+	return nil
+}
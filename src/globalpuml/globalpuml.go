@@ -4,37 +4,96 @@ import (
 	"os"
 	"path/filepath"
 	"fmt"
+	"flag"
+	"strings"
+	"time"
 
 	"errors"
 	"../parser"
+	"../audit"
 	"../puml"
+	"../mermaid"
+	"../dot"
+	"../d2"
+	"../model"
+	"../c4"
+	"../structurizr"
+	"../graph"
+	"../explorer"
+	"../markdown"
+	"../xmi"
+	"../callgraph"
+	"../sequence"
+	"../style"
 	"../util"
 )
 
+const USAGE = `Usage: globalpuml [audit] (root source directory) [options]
+       globalpuml render (model.json) [options]
+       globalpuml sequence (root source directory) (pkg.Func or pkg.Type.Method) [options]`
+
+// Diagram renderers by --format.
+var formats = map[string]func(*puml.PlantUML) error{
+	"puml": puml.RenderPUML,
+	"mermaid": mermaid.RenderMermaid,
+	"dot": dot.RenderDOT,
+	"d2": d2.RenderD2,
+	"json": model.RenderJSON,
+	"c4": c4.RenderC4,
+	"structurizr": structurizr.RenderStructurizr,
+	"graphml": graph.RenderGraphML,
+	"gexf": graph.RenderGEXF,
+	"html": explorer.RenderHTML,
+	"markdown": markdown.RenderMarkdown,
+	"xmi": xmi.RenderXMI,
+}
+
 func eoe(err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error() + "\n")
+		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		eoe(errors.New("Usage: globalpuml (root source directory) [-d | -g]"))
-	}
-
-	if len(os.Args) == 3 {
-		switch os.Args[2] {
-		case "-d":
-			util.Debug = true
-			util.Global = true
-		case "-g":
-			util.Global = true
-		}
+func newFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("globalpuml", flag.ExitOnError)
+	flags.BoolVar(&util.Debug, "d", false, "dump the JSON data collected and relationships")
+	flags.BoolVar(&util.Global, "g", false, "include relationships between <package>Global and structs of the same package")
+	flags.StringVar(&util.Signatures, "signatures", "full", "method signatures: full or short (types only)")
+	flags.BoolVar(&util.Audit, "audit", false, "highlight mutable and shared package vars on the <package>Global objects")
+	flags.StringVar(&util.Visibility, "visibility", "all", "members shown: all or exported")
+	flags.StringVar(&util.Focus, "focus", "", "only draw classes around this class, eg. pkg.Type")
+	flags.IntVar(&util.Depth, "depth", 1, "hops from the focus class, or levels of calls followed by sequence")
+	flags.StringVar(&util.Direction, "direction", "both", "relationships followed from the focus class: in, out or both")
+	flags.StringVar(&util.Level, "level", "class", "diagram level: class or package")
+	flags.StringVar(&util.Format, "format", "", "output format. diagram: puml, mermaid, dot, d2, json, c4, structurizr, graphml, gexf, html, markdown or xmi, audit: table or json")
+	flags.StringVar(&util.System, "system", "", "structurizr software system name")
+	flags.StringVar(&util.Merge, "merge", "", "structurizr workspace to update in place, only between globalpuml:begin and globalpuml:end markers")
+	flags.StringVar(&util.Out, "out", ".", "directory markdown pages and rendered diagrams are written to")
+	flags.StringVar(&util.Style, "style", "", "puml style preset: default, dark, plain or mono, overrides the config file preset")
+	flags.StringVar(&util.Config, "config", "", "config file with the puml style, globalpuml.json when it exists")
+	flags.StringVar(&util.Split, "split", "", "package: write <out>/<package>.puml per package and <out>/index.puml linking to them")
+	flags.StringVar(&util.Render, "render", "", "write the puml or c4 diagram to <out>/diagram.puml, or the sequence diagram to <out>/sequence.puml, and render it next to it: svg or png")
+	flags.StringVar(&util.PlantUML, "plantuml", os.Getenv("PLANTUML_JAR"), "plantuml.jar used by --render, run with java")
+	flags.StringVar(&util.Server, "server", "", "PlantUML server used by --render instead of plantuml.jar, eg. http://localhost:8080")
+	flags.DurationVar(&util.Timeout, "timeout", time.Minute, "--render timeout")
+	flags.StringVar(&util.Rankdir, "rankdir", "TB", "layout direction: TB, LR, BT or RL for dot, TB or LR for puml")
+	flags.BoolVar(&util.HideEmpty, "hide-empty", false, "puml: hide empty members")
+	flags.BoolVar(&util.Together, "together", false, "puml: group classes related within a package in together blocks")
+	flags.BoolVar(&util.Layering, "layering", false, "puml: order packages by dependency depth, lower level packages at the bottom")
+	flags.BoolVar(&util.CollapsePrefix, "collapse-prefix", false, "puml: trim the path prefix all packages share, eg. the module path")
+	flags.BoolVar(&util.Calls, "calls", false, "type check the sources and draw calls between classes, through interfaces to their implementations")
+	flags.BoolVar(&util.Concentrate, "concentrate", false, "merge parallel dot edges")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, USAGE)
+		flags.PrintDefaults()
 	}
+	return flags
+}
 
+func sources(root string) ([]string, error) {
 	sources := make([]string, 0)
-	err := filepath.Walk(os.Args[1],
+	err := filepath.Walk(root,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
@@ -44,9 +103,118 @@ func main() {
 			}
 			return nil
 		})
+	return sources, err
+}
+
+func main() {
+	args := os.Args[1:]
+	command := "diagram"
+	if len(args) > 0 && (args[0] == "audit" || args[0] == "render" || args[0] == "sequence") {
+		command, args = args[0], args[1:]
+	}
+
+	// Positional arguments before the options.
+	positional := 1
+	if command == "sequence" {
+		positional = 2
+	}
+	flags := newFlags()
+	if len(args) < positional || strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[positional-1], "-") {
+		flags.Usage()
+		os.Exit(1)
+	}
+	eoe(flags.Parse(args[positional:]))
+
+	if util.Debug {
+		util.Global = true
+	}
+
+	if util.Signatures != "full" && util.Signatures != "short" {
+		eoe(errors.New(USAGE))
+	}
+
+	if util.Visibility != "all" && util.Visibility != "exported" {
+		eoe(fmt.Errorf("Unknown visibility: %s", util.Visibility))
+	}
+
+	if util.Direction != "in" && util.Direction != "out" && util.Direction != "both" {
+		eoe(fmt.Errorf("Unknown direction: %s", util.Direction))
+	}
+
+	if util.Level != "class" && util.Level != "package" {
+		eoe(fmt.Errorf("Unknown level: %s", util.Level))
+	}
+
+	switch util.Rankdir {
+	case "TB", "LR":
+	case "BT", "RL":
+		if command != "audit" && (util.Format == "puml" || util.Format == "") {
+			eoe(fmt.Errorf("Rankdir %s is only supported by dot", util.Rankdir))
+		}
+	default:
+		eoe(fmt.Errorf("Unknown rankdir: %s", util.Rankdir))
+	}
+
+	if util.Depth < 0 {
+		eoe(errors.New("Depth can't be negative"))
+	}
+
+	switch {
+	case command == "audit" && util.Format != "" && util.Format != "table" && util.Format != "json":
+		eoe(fmt.Errorf("Unknown audit format: %s", util.Format))
+	case command == "sequence" && util.Format != "" && util.Format != "puml":
+		eoe(errors.New("sequence only works with --format=puml"))
+	case command != "audit" && util.Format == "":
+		util.Format = "puml"
+	case command != "audit" && formats[util.Format] == nil:
+		eoe(fmt.Errorf("Unknown format: %s", util.Format))
+	}
+
+	eoe(style.Load(util.Config, util.Style))
+
+	switch {
+	case util.Split == "":
+	case util.Split != "package":
+		eoe(fmt.Errorf("Unknown split: %s", util.Split))
+	case command == "audit" || command == "sequence" || util.Format != "puml":
+		eoe(errors.New("--split only works with --format=puml class diagrams"))
+	}
+
+	switch {
+	case util.Render == "":
+	case util.Render != "svg" && util.Render != "png":
+		eoe(fmt.Errorf("Unknown render format: %s", util.Render))
+	case command == "audit" || (util.Format != "puml" && util.Format != "c4"):
+		eoe(errors.New("--render only works with --format=puml or c4"))
+	case util.PlantUML == "" && util.Server == "":
+		eoe(errors.New("--render needs --plantuml or --server"))
+	}
+
+	if command == "render" {
+		uml, err := model.Load(args[0])
+		eoe(err)
+		eoe(uml.Filter())
+		eoe(formats[util.Format](uml))
+		return
+	}
+
+	files, err := sources(args[0])
 	eoe(err)
 	
-	p, err := parser.Parser(sources)
+	p, err := parser.Parser(files)
 	eoe(err)
-	eoe(puml.GeneratePUML(p))
-}
\ No newline at end of file
+
+	switch {
+	case command == "audit":
+		eoe(audit.PrintAudit(p))
+	case command == "sequence":
+		eoe(sequence.RenderSequence(callgraph.Build(p), args[1]))
+	default:
+		if util.Calls {
+			callgraph.Build(p).Annotate(p)
+		}
+		uml, err := puml.ParseToPUML(p)
+		eoe(err)
+		eoe(formats[util.Format](uml))
+	}
+}
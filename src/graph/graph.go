@@ -0,0 +1,197 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"../model"
+	"../parser"
+	"../puml"
+)
+
+// A class with the attributes exported for graph tools.
+type Node struct {
+	ID				string
+	Label			string
+	Package			string
+	Kind			string
+	Fields			int
+	Methods			int
+	Exported		bool
+}
+
+// A relationship and the number of functions behind it.
+type Link struct {
+	puml.Edge
+	Count			int
+}
+
+func escape(s string) string {
+	buffer := &bytes.Buffer{}
+	xml.EscapeText(buffer, []byte(s))
+	return buffer.String()
+}
+
+func Nodes(uml *puml.PlantUML) []Node {
+	nodes := make([]Node, 0)
+	for _, ns := range uml.SortedNamespaces() {
+		for _, c := range ns.SortedClasses() {
+			n := Node{ID: ns.Name + "." + c.Name, Label: c.Name, Package: ns.Name, Kind: model.Kind(c)}
+			n.Fields = len(c.PrivateVars) + len(c.PublicVars)
+			n.Methods = len(c.PrivateFuncs) + len(c.PublicFuncs)
+			n.Exported = n.Kind == "global" || strings.ToUpper(c.Name[:1]) == c.Name[:1]
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// Functions of class referencing key, or calling its functions for calls
+// edges. At least 1 as relationships can come from elsewhere, eg. a saved
+// model.
+func count(uml *puml.PlantUML, class, key, kind string) int {
+	i := strings.LastIndex(class, ".")
+	c := uml.Namespaces[class[:i]].Classes[class[i+1:]]
+	n := 0
+	for _, funcs := range []map[string]parser.Func{c.PrivateFuncs, c.PublicFuncs} {
+		for _, fn := range funcs {
+			if kind != puml.CALLS {
+				if _, exists := fn.Relationships[key]; exists {
+					n++
+				}
+				continue
+			}
+			for k, _ := range fn.Calls {
+				if strings.HasPrefix(k, key + ".") && !strings.Contains(k[len(key)+1:], ".") {
+					n++
+					break
+				}
+			}
+		}
+	}
+	return n
+}
+
+// Relationships between classes of the diagram.
+func Links(uml *puml.PlantUML) []Link {
+	links := make([]Link, 0)
+	for _, e := range uml.Edges() {
+		if !uml.HasClass(e.From) || !uml.HasClass(e.To) {
+			continue
+		}
+		n := count(uml, e.From, e.To, e.Kind)
+		if e.Kind == puml.ASSOCIATION {
+			n += count(uml, e.To, e.From, e.Kind)
+		}
+		if n == 0 {
+			n = 1
+		}
+		links = append(links, Link{Edge: e, Count: n})
+	}
+	return links
+}
+
+const (
+	GRAPHML_HEADER = `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+	<key id="label" for="node" attr.name="label" attr.type="string"/>
+	<key id="package" for="node" attr.name="package" attr.type="string"/>
+	<key id="kind" for="node" attr.name="kind" attr.type="string"/>
+	<key id="fields" for="node" attr.name="fields" attr.type="int"/>
+	<key id="methods" for="node" attr.name="methods" attr.type="int"/>
+	<key id="exported" for="node" attr.name="exported" attr.type="boolean"/>
+	<key id="relationship" for="edge" attr.name="kind" attr.type="string"/>
+	<key id="count" for="edge" attr.name="count" attr.type="int"/>
+	<graph id="globalpuml" edgedefault="directed">
+`
+	GRAPHML_NODE = "\t\t<node id=\"%s\">\n" +
+		"\t\t\t<data key=\"label\">%s</data>\n" +
+		"\t\t\t<data key=\"package\">%s</data>\n" +
+		"\t\t\t<data key=\"kind\">%s</data>\n" +
+		"\t\t\t<data key=\"fields\">%d</data>\n" +
+		"\t\t\t<data key=\"methods\">%d</data>\n" +
+		"\t\t\t<data key=\"exported\">%t</data>\n" +
+		"\t\t</node>\n"
+	GRAPHML_EDGE = "\t\t<edge id=\"e%d\" source=\"%s\" target=\"%s\" directed=\"%t\">\n" +
+		"\t\t\t<data key=\"relationship\">%s</data>\n" +
+		"\t\t\t<data key=\"count\">%d</data>\n" +
+		"\t\t</edge>\n"
+	GRAPHML_FOOTER = "\t</graph>\n</graphml>\n"
+)
+
+func GraphMLString(uml *puml.PlantUML) string {
+	s := GRAPHML_HEADER
+	for _, n := range Nodes(uml) {
+		s += fmt.Sprintf(GRAPHML_NODE, escape(n.ID), escape(n.Label), escape(n.Package), n.Kind, n.Fields, n.Methods, n.Exported)
+	}
+	for i, l := range Links(uml) {
+		s += fmt.Sprintf(GRAPHML_EDGE, i, escape(l.From), escape(l.To), l.Kind != puml.ASSOCIATION, l.Kind, l.Count)
+	}
+	return s + GRAPHML_FOOTER
+}
+
+const (
+	GEXF_HEADER = `<?xml version="1.0" encoding="UTF-8"?>
+<gexf xmlns="http://gexf.net/1.3" version="1.3">
+	<graph defaultedgetype="directed">
+		<attributes class="node">
+			<attribute id="package" title="package" type="string"/>
+			<attribute id="kind" title="kind" type="string"/>
+			<attribute id="fields" title="fields" type="integer"/>
+			<attribute id="methods" title="methods" type="integer"/>
+			<attribute id="exported" title="exported" type="boolean"/>
+		</attributes>
+		<attributes class="edge">
+			<attribute id="kind" title="kind" type="string"/>
+			<attribute id="count" title="count" type="integer"/>
+		</attributes>
+`
+	GEXF_NODE = "\t\t\t<node id=\"%s\" label=\"%s\">\n" +
+		"\t\t\t\t<attvalues>\n" +
+		"\t\t\t\t\t<attvalue for=\"package\" value=\"%s\"/>\n" +
+		"\t\t\t\t\t<attvalue for=\"kind\" value=\"%s\"/>\n" +
+		"\t\t\t\t\t<attvalue for=\"fields\" value=\"%d\"/>\n" +
+		"\t\t\t\t\t<attvalue for=\"methods\" value=\"%d\"/>\n" +
+		"\t\t\t\t\t<attvalue for=\"exported\" value=\"%t\"/>\n" +
+		"\t\t\t\t</attvalues>\n" +
+		"\t\t\t</node>\n"
+	GEXF_EDGE = "\t\t\t<edge id=\"%d\" source=\"%s\" target=\"%s\" type=\"%s\" weight=\"%d\">\n" +
+		"\t\t\t\t<attvalues>\n" +
+		"\t\t\t\t\t<attvalue for=\"kind\" value=\"%s\"/>\n" +
+		"\t\t\t\t\t<attvalue for=\"count\" value=\"%d\"/>\n" +
+		"\t\t\t\t</attvalues>\n" +
+		"\t\t\t</edge>\n"
+	GEXF_FOOTER = "\t</graph>\n</gexf>\n"
+)
+
+func GEXFString(uml *puml.PlantUML) string {
+	s := GEXF_HEADER
+	s += "\t\t<nodes>\n"
+	for _, n := range Nodes(uml) {
+		s += fmt.Sprintf(GEXF_NODE, escape(n.ID), escape(n.Label), escape(n.Package), n.Kind, n.Fields, n.Methods, n.Exported)
+	}
+	s += "\t\t</nodes>\n"
+
+	s += "\t\t<edges>\n"
+	for i, l := range Links(uml) {
+		typ := "directed"
+		if l.Kind == puml.ASSOCIATION {
+			typ = "undirected"
+		}
+		s += fmt.Sprintf(GEXF_EDGE, i, escape(l.From), escape(l.To), typ, l.Count, l.Kind, l.Count)
+	}
+	s += "\t\t</edges>\n"
+	return s + GEXF_FOOTER
+}
+
+func RenderGraphML(uml *puml.PlantUML) error {
+	fmt.Print(GraphMLString(uml))
+	return nil
+}
+
+func RenderGEXF(uml *puml.PlantUML) error {
+	fmt.Print(GEXFString(uml))
+	return nil
+}
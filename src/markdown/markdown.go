@@ -0,0 +1,251 @@
+package markdown
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+
+	"../mermaid"
+	"../model"
+	"../parser"
+	"../puml"
+	"../util"
+)
+
+// Sections of the types of a package by kind, in order.
+var sections = []struct {
+	Kind			string
+	Title			string
+}{
+	{"struct", "Structs"},
+	{"interface", "Interfaces"},
+	{"func", "Func types"},
+	{"type", "Other types"},
+}
+
+func code(s string) string {
+	if strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+	return "`" + s + "`"
+}
+
+// Doc comments are plain text, "<" would start inline HTML.
+func text(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// Table cells can't hold "|" or line breaks.
+func cell(s string) string {
+	s = strings.Replace(s, "|", "\\|", -1)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func position(pos parser.Position) string {
+	if pos.Line == 0 {
+		return ""
+	}
+	return code(fmt.Sprintf("%s:%d", pos.File, pos.Line))
+}
+
+// Relative link from the page of package from to the page of package to.
+func link(from, to string) string {
+	rel, err := filepath.Rel(filepath.Dir(from), to + ".md")
+	if err != nil {
+		rel = to + ".md"
+	}
+	return fmt.Sprintf("[%s](%s)", code(to), filepath.ToSlash(rel))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0)
+	for k, _ := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFuncs(c puml.Class) []parser.Func {
+	funcs := make([]parser.Func, 0)
+	for _, fn := range c.PublicFuncs {
+		funcs = append(funcs, fn)
+	}
+	for _, fn := range c.PrivateFuncs {
+		funcs = append(funcs, fn)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].Name < funcs[j].Name
+	})
+	return funcs
+}
+
+// Fields table, consts apart on the <Pkg>Global object.
+func fields(c puml.Class, consts bool) string {
+	vars := make(map[string]string)
+	for k, v := range c.PrivateVars {
+		vars[k] = v
+	}
+	for k, v := range c.PublicVars {
+		vars[k] = v
+	}
+
+	var md string
+	for _, name := range sortedKeys(vars) {
+		if _, isConst := c.Consts[name]; isConst != consts {
+			continue
+		}
+		typ := vars[name]
+		if typ != "" {
+			typ = code(typ)
+		}
+		md += fmt.Sprintf("| %s | %s | %s | %s |\n", code(name), cell(typ), position(c.VarPositions[name]), cell(text(c.VarDocs[name])))
+	}
+	if md == "" {
+		return ""
+	}
+	return "| Name | Type | Source | Description |\n| --- | --- | --- | --- |\n" + md
+}
+
+func funcs(c puml.Class) string {
+	var md string
+	for _, fn := range sortedFuncs(c) {
+		md += "- " + code(puml.FuncString(fn))
+		if pos := position(fn.Pos); pos != "" {
+			md += " " + pos
+		}
+		if fn.Goroutine {
+			md += " (goroutine)"
+		}
+		md += "\n"
+		if fn.Doc != "" {
+			md += "\n" + indent(text(fn.Doc), "  ")
+		}
+	}
+	return md
+}
+
+func indent(text, prefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func name(c puml.Class) string {
+	if len(c.TypeParams) != 0 {
+		return c.Name + "[" + puml.ParamsString(c.TypeParams) + "]"
+	}
+	return c.Name
+}
+
+func globalString(c puml.Class) string {
+	md := "## Globals\n\n"
+	md += fmt.Sprintf("Package level members, drawn as %s.\n\n", code(c.Name))
+	if c.Collapsed {
+		return md + "Members left out of this diagram.\n\n"
+	}
+	if table := fields(c, true); table != "" {
+		md += "### Constants\n\n" + table + "\n"
+	}
+	if table := fields(c, false); table != "" {
+		md += "### Variables\n\n" + table + "\n"
+	}
+	if list := funcs(c); list != "" {
+		md += "### Functions\n\n" + list + "\n"
+	}
+	return md
+}
+
+func typeString(c puml.Class, kind string) string {
+	md := "### " + code(name(c)) + "\n\n"
+	if c.Doc != "" {
+		md += text(c.Doc) + "\n\n"
+	}
+	if kind != "struct" {
+		md += "Type " + code(strings.TrimSpace(strings.TrimSuffix(c.Type, "{"))) + ".\n"
+	}
+	if pos := position(c.Pos); pos != "" {
+		md += "Defined in " + pos + ".\n"
+	}
+	md += "\n"
+	if c.Collapsed {
+		return md + "Members left out of this diagram.\n\n"
+	}
+	if table := fields(c, false); table != "" {
+		md += "#### Fields\n\n" + table + "\n"
+	}
+	if list := funcs(c); list != "" {
+		md += "#### Methods\n\n" + list + "\n"
+	}
+	return md
+}
+
+// Page of ns with its class diagram. Relationships with types of other
+// packages are kept so the diagram shows what the package talks to.
+func PackageString(uml *puml.PlantUML, ns puml.Namespace) string {
+	md := fmt.Sprintf("# Package %s\n\n", code(ns.Name))
+	if ns.Doc != "" {
+		md += text(ns.Doc) + "\n\n"
+	}
+	if ns.Module != "" {
+		md += "Module " + code(ns.Module) + ".\n\n"
+	}
+
+	deps := make([]string, 0)
+	for dep, n := range ns.Dependencies {
+		if _, exists := uml.Namespaces[dep]; !exists {
+			continue
+		}
+		if n != 0 {
+			deps = append(deps, fmt.Sprintf("%s (%s)", link(ns.Name, dep), util.References(n)))
+		} else {
+			deps = append(deps, link(ns.Name, dep))
+		}
+	}
+	sort.Strings(deps)
+	if len(deps) != 0 {
+		md += "Depends on " + strings.Join(deps, ", ") + ".\n\n"
+	}
+
+	single := puml.InitPlantUML()
+	single.Namespaces[ns.Name] = ns
+	md += "## Diagram\n\n```mermaid\n" + mermaid.ClassDiagramString(&single) + "```\n\n"
+
+	classes := ns.SortedClasses()
+	for _, c := range classes {
+		if model.Kind(c) == "global" {
+			md += globalString(c)
+		}
+	}
+	for _, section := range sections {
+		var types string
+		for _, c := range classes {
+			if model.Kind(c) == section.Kind {
+				types += typeString(c, section.Kind)
+			}
+		}
+		if types != "" {
+			md += "## " + section.Title + "\n\n" + types
+		}
+	}
+	return strings.TrimSuffix(md, "\n")
+}
+
+// Write one page per package under util.Out, eg. "a/b" to "<out>/a/b.md".
+func RenderMarkdown(uml *puml.PlantUML) error {
+	for _, ns := range uml.SortedNamespaces() {
+		path := filepath.Join(util.Out, filepath.FromSlash(ns.Name) + ".md")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(PackageString(uml, ns)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
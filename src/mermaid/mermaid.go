@@ -0,0 +1,195 @@
+package mermaid
+
+import (
+	"fmt"
+	"strings"
+	"regexp"
+
+	"../parser"
+	"../puml"
+	"../util"
+)
+
+const (
+	CLASS = "class %s[\"%s\"] {\n"
+	ASSOCIATION = "%s -- %s\n"
+	DEPENDENCY = "%s --> %s\n"
+	CALLS = "%s ..> %s : calls\n"
+	WEIGHTED_DEPENDENCY = "%s -->|%d| %s\n"
+)
+
+// Mermaid ids may only hold letters, digits and underscores, so the
+// collision free PlantUML ids are reused.
+func id(key string) string {
+	return puml.ClassAlias(key)
+}
+
+// Namespace names by namespace: the path with what isn't a letter, digit or
+// underscore replaced by "_", eg. "store_sql" for "store/sql". Namespaces that
+// would share a name, or take a class id, keep their collision free id.
+func namespaceNames(uml *puml.PlantUML) map[string]string {
+	re := regexp.MustCompile("[^a-zA-Z\\d_]")
+	taken := make(map[string][]string)
+	for _, ns := range uml.SortedNamespaces() {
+		name := re.ReplaceAllString(ns.Name, "_")
+		taken[name] = append(taken[name], ns.Name)
+		for _, c := range ns.SortedClasses() {
+			taken[id(ns.Name + "." + c.Name)] = append(taken[id(ns.Name + "." + c.Name)], "")
+		}
+	}
+
+	names := make(map[string]string)
+	for name, namespaces := range taken {
+		for _, ns := range namespaces {
+			if ns == "" {
+				continue
+			}
+			if len(namespaces) == 1 {
+				names[ns] = name
+			} else {
+				names[ns] = puml.PackageAlias(ns)
+			}
+		}
+	}
+	return names
+}
+
+// Go generics such as "List[T]" become "List~T~". Brackets and braces left
+// over, eg. "[]string" or "interface{}", are escaped as entity codes since
+// Mermaid reads them as syntax.
+func escape(text string) string {
+	re := regexp.MustCompile("([A-Za-z_][A-Za-z\\d_]*)\\[([^\\[\\]]+)\\]")
+	text = re.ReplaceAllStringFunc(text, func(match string) string {
+		split := re.FindStringSubmatch(match)
+		if split[1] == "map" {
+			return match
+		}
+		return split[1] + "~" + split[2] + "~"
+	})
+
+	replacer := strings.NewReplacer(
+		"[", "#91;",
+		"]", "#93;",
+		"{", "#123;",
+		"}", "#125;",
+		"\"", "#quot;",
+	)
+	return replacer.Replace(text)
+}
+
+func annotation(c puml.Class) string {
+	switch c.Type {
+	case "global":
+		return "<<global>>"
+	case "struct":
+		return "<<struct>>"
+	default:
+		return "<<type>>"
+	}
+}
+
+// Mermaid puts the return type after the parameters, separated by a space.
+func funcString(fn parser.Func) string {
+	s := fn.Name + "(" + puml.ParamsString(fn.Params) + ")"
+	switch {
+	case len(fn.Results) == 0:
+	case len(fn.Results) == 1 && (fn.Results[0].Name == "" || util.Signatures == "short"):
+		s += " " + fn.Results[0].Type
+	default:
+		s += " (" + puml.ParamsString(fn.Results) + ")"
+	}
+	return escape(s)
+}
+
+func ClassString(namespace string, c puml.Class) string {
+	class := id(namespace + "." + c.Name)
+	// Mermaid generics can't hold commas or constraints, only the names.
+	if len(c.TypeParams) != 0 {
+		names := make([]string, 0)
+		for _, p := range c.TypeParams {
+			names = append(names, p.Name)
+		}
+		class += "~" + strings.Join(names, " ") + "~"
+	}
+
+	mmd := fmt.Sprintf(CLASS, class, escape(namespace + "." + c.Name))
+	mmd += "\t" + annotation(c) + "\n"
+	if c.Collapsed {
+		return mmd + "}\n"
+	}
+
+	for _, k := range puml.SortedVars(c.PrivateVars) {
+		mmd += fmt.Sprintf("\t-%s %s\n", k, escape(c.PrivateVars[k]))
+	}
+	for _, k := range puml.SortedVars(c.PublicVars) {
+		mmd += fmt.Sprintf("\t+%s %s\n", k, escape(c.PublicVars[k]))
+	}
+	for _, fn := range puml.SortedFuncs(c.PrivateFuncs) {
+		mmd += fmt.Sprintf("\t-%s\n", funcString(fn))
+	}
+	for _, fn := range puml.SortedFuncs(c.PublicFuncs) {
+		mmd += fmt.Sprintf("\t+%s\n", funcString(fn))
+	}
+	return mmd + "}\n"
+}
+
+// Package level view as a flowchart, as classDiagram has no plain nodes.
+func PackageString(uml *puml.PlantUML) string {
+	mmd := "flowchart LR\n"
+	for _, ns := range uml.SortedNamespaces() {
+		mmd += fmt.Sprintf("%s[\"%s\"]\n", puml.PackageAlias(ns.Name), ns.Name)
+	}
+
+	for _, ns := range uml.SortedNamespaces() {
+		for _, dep := range uml.SortedDependencies(ns) {
+			if n := ns.Dependencies[dep]; n != 0 {
+				mmd += fmt.Sprintf(WEIGHTED_DEPENDENCY, puml.PackageAlias(ns.Name), n, puml.PackageAlias(dep))
+			} else {
+				mmd += fmt.Sprintf(DEPENDENCY, puml.PackageAlias(ns.Name), puml.PackageAlias(dep))
+			}
+		}
+	}
+	return mmd
+}
+
+// Class level view, whatever the --level.
+func ClassDiagramString(uml *puml.PlantUML) string {
+	mmd := "classDiagram\n"
+	names := namespaceNames(uml)
+	for _, ns := range uml.SortedNamespaces() {
+		mmd += fmt.Sprintf("namespace %s {\n", names[ns.Name])
+		for _, c := range ns.SortedClasses() {
+			mmd += ClassString(ns.Name, c)
+		}
+		mmd += "}\n"
+	}
+
+	// Edges to classes that aren't drawn, eg. of other packages on a markdown
+	// page, would be drawn as bare ids.
+	for _, e := range uml.Edges() {
+		if !uml.HasClass(e.From) || !uml.HasClass(e.To) {
+			continue
+		}
+		switch e.Kind {
+		case puml.ASSOCIATION:
+			mmd += fmt.Sprintf(ASSOCIATION, id(e.From), id(e.To))
+		case puml.CALLS:
+			mmd += fmt.Sprintf(CALLS, id(e.From), id(e.To))
+		default:
+			mmd += fmt.Sprintf(DEPENDENCY, id(e.From), id(e.To))
+		}
+	}
+	return mmd
+}
+
+func MermaidString(uml *puml.PlantUML) string {
+	if util.Level == "package" {
+		return PackageString(uml)
+	}
+	return ClassDiagramString(uml)
+}
+
+func RenderMermaid(uml *puml.PlantUML) error {
+	fmt.Print(MermaidString(uml))
+	return nil
+}
@@ -0,0 +1,353 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"io/ioutil"
+	"encoding/json"
+
+	"../parser"
+	"../puml"
+	"../util"
+)
+
+// Version of the JSON model, see schema/model.schema.json. The major version
+// is bumped on any change that breaks consumers.
+const VERSION = "1.0"
+
+type Model struct {
+	Version			string				`json:"version"`
+	Packages		[]Package			`json:"packages"`
+	Relationships	[]Relationship		`json:"relationships"`
+}
+
+type Package struct {
+	Name			string				`json:"name"`
+	Module			string				`json:"module,omitempty"`
+	Doc				string				`json:"doc,omitempty"`
+	Dependencies	[]Dependency		`json:"dependencies"`
+	Types			[]Type				`json:"types"`
+}
+
+// An imported package and the number of type level references behind it.
+type Dependency struct {
+	Package			string				`json:"package"`
+	References		int					`json:"references"`
+}
+
+type Type struct {
+	Name			string				`json:"name"`
+	Kind			string				`json:"kind"`
+	Underlying		string				`json:"underlying,omitempty"`
+	Exported		bool				`json:"exported"`
+	Collapsed		bool				`json:"collapsed,omitempty"`
+	TypeParams		[]Param				`json:"typeParams,omitempty"`
+	Position		*Position			`json:"position,omitempty"`
+	Doc				string				`json:"doc,omitempty"`
+	Embeds			[]string			`json:"embeds,omitempty"`
+	Fields			[]Field				`json:"fields"`
+	Methods			[]Method			`json:"methods"`
+}
+
+type Field struct {
+	Name			string				`json:"name"`
+	Type			string				`json:"type"`
+	Exported		bool				`json:"exported"`
+	Const			bool				`json:"const,omitempty"`
+	Position		*Position			`json:"position,omitempty"`
+	Doc				string				`json:"doc,omitempty"`
+}
+
+type Method struct {
+	Name			string				`json:"name"`
+	Exported		bool				`json:"exported"`
+	TypeParams		[]Param				`json:"typeParams,omitempty"`
+	Params			[]Param				`json:"params"`
+	Results			[]Param				`json:"results"`
+	Variadic		bool				`json:"variadic,omitempty"`
+	Goroutine		bool				`json:"goroutine,omitempty"`
+	Position		*Position			`json:"position,omitempty"`
+	Doc				string				`json:"doc,omitempty"`
+	Calls			[]string			`json:"calls,omitempty"`
+}
+
+type Param struct {
+	Name			string				`json:"name,omitempty"`
+	Type			string				`json:"type"`
+}
+
+type Relationship struct {
+	From			string				`json:"from"`
+	To				string				`json:"to"`
+	Kind			string				`json:"kind"`
+}
+
+type Position struct {
+	File			string				`json:"file"`
+	Line			int					`json:"line"`
+}
+
+func exported(name string) bool {
+	return strings.ToUpper(string(name[0])) == string(name[0])
+}
+
+func position(pos parser.Position) *Position {
+	if pos.Line == 0 {
+		return nil
+	}
+	return &Position{File: pos.File, Line: pos.Line}
+}
+
+func params(ps []parser.Param) []Param {
+	m := make([]Param, 0)
+	for _, p := range ps {
+		m = append(m, Param{Name: p.Name, Type: p.Type})
+	}
+	return m
+}
+
+// Kind of a class: global, struct, interface, func or type.
+func Kind(c puml.Class) string {
+	switch {
+	case c.Type == "global" || c.Type == "struct":
+		return c.Type
+	case strings.HasPrefix(c.Type, "interface"):
+		return "interface"
+	case strings.HasPrefix(c.Type, "func"):
+		return "func"
+	default:
+		return "type"
+	}
+}
+
+func exportType(c puml.Class) Type {
+	t := Type{Name: c.Name, Kind: Kind(c), Collapsed: c.Collapsed}
+	if t.Kind != "global" && t.Kind != "struct" {
+		t.Underlying = strings.TrimSpace(strings.TrimSuffix(c.Type, "{"))
+	}
+	t.Exported = t.Kind == "global" || exported(c.Name)
+	t.TypeParams = params(c.TypeParams)
+	t.Position = position(c.Pos)
+	t.Doc = c.Doc
+	t.Embeds = c.Embeds
+
+	t.Fields = make([]Field, 0)
+	for _, vars := range []map[string]string{c.PrivateVars, c.PublicVars} {
+		for k, v := range vars {
+			_, isConst := c.Consts[k]
+			t.Fields = append(t.Fields, Field{Name: k, Type: v, Exported: exported(k), Const: isConst, Position: position(c.VarPositions[k]), Doc: c.VarDocs[k]})
+		}
+	}
+	sort.Slice(t.Fields, func(i, j int) bool {
+		return t.Fields[i].Name < t.Fields[j].Name
+	})
+
+	t.Methods = make([]Method, 0)
+	for _, funcs := range []map[string]parser.Func{c.PrivateFuncs, c.PublicFuncs} {
+		for _, fn := range funcs {
+			m := Method{Name: fn.Name, Exported: exported(fn.Name), Variadic: fn.Variadic, Goroutine: fn.Goroutine}
+			m.TypeParams = params(fn.TypeParams)
+			m.Params = params(fn.Params)
+			m.Results = params(fn.Results)
+			m.Position = position(fn.Pos)
+			m.Doc = fn.Doc
+			for k, _ := range fn.Calls {
+				m.Calls = append(m.Calls, k)
+			}
+			sort.Strings(m.Calls)
+			t.Methods = append(t.Methods, m)
+		}
+	}
+	sort.Slice(t.Methods, func(i, j int) bool {
+		return t.Methods[i].Name < t.Methods[j].Name
+	})
+	return t
+}
+
+// Export the diagram model. Every list is sorted so the output is stable.
+func Export(uml *puml.PlantUML) Model {
+	m := Model{Version: VERSION}
+	m.Packages = make([]Package, 0)
+	for _, ns := range uml.SortedNamespaces() {
+		pkg := Package{Name: ns.Name, Module: ns.Module, Doc: ns.Doc}
+		pkg.Dependencies = make([]Dependency, 0)
+		for dep, n := range ns.Dependencies {
+			pkg.Dependencies = append(pkg.Dependencies, Dependency{Package: dep, References: n})
+		}
+		sort.Slice(pkg.Dependencies, func(i, j int) bool {
+			return pkg.Dependencies[i].Package < pkg.Dependencies[j].Package
+		})
+
+		pkg.Types = make([]Type, 0)
+		for _, c := range ns.SortedClasses() {
+			pkg.Types = append(pkg.Types, exportType(c))
+		}
+		m.Packages = append(m.Packages, pkg)
+	}
+
+	m.Relationships = make([]Relationship, 0)
+	for _, e := range uml.Edges() {
+		m.Relationships = append(m.Relationships, Relationship{From: e.From, To: e.To, Kind: e.Kind})
+	}
+	return m
+}
+
+func RenderJSON(uml *puml.PlantUML) error {
+	data, err := util.Dump(Export(uml))
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	return nil
+}
+
+func importParams(ps []Param) []parser.Param {
+	params := make([]parser.Param, 0)
+	for _, p := range ps {
+		params = append(params, parser.Param{Name: p.Name, Type: p.Type})
+	}
+	return params
+}
+
+func importPosition(pos *Position) parser.Position {
+	if pos == nil {
+		return parser.Position{}
+	}
+	return parser.Position{File: pos.File, Line: pos.Line}
+}
+
+func importType(t Type) puml.Class {
+	c := puml.InitClass()
+	c.Name = t.Name
+	c.Type = t.Kind
+	if t.Underlying != "" {
+		c.Type = t.Underlying
+	}
+	c.Collapsed = t.Collapsed
+	if len(t.TypeParams) != 0 {
+		c.TypeParams = importParams(t.TypeParams)
+	}
+	c.Pos = importPosition(t.Position)
+	c.Doc = t.Doc
+	c.Embeds = t.Embeds
+
+	for _, f := range t.Fields {
+		if f.Exported {
+			c.PublicVars[f.Name] = f.Type
+		} else {
+			c.PrivateVars[f.Name] = f.Type
+		}
+		if f.Const {
+			c.Consts[f.Name] = struct{}{}
+		}
+		if f.Position != nil {
+			c.VarPositions[f.Name] = importPosition(f.Position)
+		}
+		if f.Doc != "" {
+			c.VarDocs[f.Name] = f.Doc
+		}
+	}
+
+	count := make(map[string]int)
+	for _, m := range t.Methods {
+		count[m.Name]++
+	}
+	for _, m := range t.Methods {
+		fn := parser.Func{Name: m.Name, Variadic: m.Variadic, Goroutine: m.Goroutine}
+		if len(m.TypeParams) != 0 {
+			fn.TypeParams = importParams(m.TypeParams)
+		}
+		fn.Params = importParams(m.Params)
+		fn.Results = importParams(m.Results)
+		fn.Relationships = make(parser.Set)
+		fn.Pos = importPosition(m.Position)
+		fn.Doc = m.Doc
+		if len(m.Calls) != 0 {
+			fn.Calls = make(parser.Set)
+			for _, k := range m.Calls {
+				fn.Calls[k] = struct{}{}
+			}
+		}
+		key := m.Name
+		if count[m.Name] > 1 {
+			key = fn.PositionKey()
+		}
+		if m.Exported {
+			c.PublicFuncs[key] = fn
+		} else {
+			c.PrivateFuncs[key] = fn
+		}
+	}
+	return c
+}
+
+// Add a relationship of kind from the "namespace.Class" key from to the key
+// to.
+func relate(uml *puml.PlantUML, from, to, kind string) error {
+	i := strings.LastIndex(from, ".")
+	if i < 0 {
+		return fmt.Errorf("Bad relationship in model: %s", from)
+	}
+	ns, exists := uml.Namespaces[from[:i]]
+	if !exists {
+		return fmt.Errorf("Relationship from unknown package: %s", from)
+	}
+	c, exists := ns.Classes[from[i+1:]]
+	if !exists {
+		return fmt.Errorf("Relationship from unknown type: %s", from)
+	}
+	if kind == puml.CALLS {
+		c.Calls[to] = struct{}{}
+	} else {
+		c.Relationships[to] = struct{}{}
+	}
+	return nil
+}
+
+// Rebuild the diagram model from a JSON model written by --format=json.
+func Import(m Model) (*puml.PlantUML, error) {
+	if !strings.HasPrefix(m.Version, strings.Split(VERSION, ".")[0] + ".") {
+		return nil, fmt.Errorf("Unsupported model version %s, expected %s", m.Version, VERSION)
+	}
+
+	uml := puml.InitPlantUML()
+	for _, pkg := range m.Packages {
+		ns := puml.InitNamespace()
+		ns.Name = pkg.Name
+		ns.Module = pkg.Module
+		ns.Doc = pkg.Doc
+		for _, dep := range pkg.Dependencies {
+			ns.Dependencies[dep.Package] = dep.References
+		}
+		for _, t := range pkg.Types {
+			ns.Classes[t.Name] = importType(t)
+		}
+		uml.Namespaces[ns.Name] = ns
+	}
+
+	for _, r := range m.Relationships {
+		if err := relate(&uml, r.From, r.To, r.Kind); err != nil {
+			return nil, err
+		}
+		if r.Kind == puml.ASSOCIATION {
+			if err := relate(&uml, r.To, r.From, r.Kind); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &uml, nil
+}
+
+func Load(path string) (*puml.PlantUML, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return Import(m)
+}
@@ -4,9 +4,14 @@ import (
 	"errors"
 	"io/ioutil"
 	"fmt"
+	"path"
 	"path/filepath"
 	"strings"
 	"regexp"
+	"os"
+	"sort"
+	"strconv"
+	"unicode"
 
 	"../util"
 )
@@ -23,23 +28,61 @@ func (s Set) MarshalJSON() ([]byte, error) {
 	for k, _ := range s {
 		a = append(a, k)
 	}
+	sort.Strings(a)
 	return util.Dump(a)
 }
 
 type Type struct {
 	Name			string
 	Type			string
+	TypeParams		[]Param					`json:"TypeParams,omitempty"`
 	PrivateVars		map[string]string		`json:"PrivateVars,omitempty"`
 	PublicVars		map[string]string		`json:"PublicVars,omitempty"`
-	PrivateFuncs	Set						`json:"PrivateFuncs,omitempty"`
-	PublicFuncs		Set						`json:"PublicFuncs,omitempty"`
+	// Funcs by name. Names declared more than once in a package, which Go
+	// allows for init, are keyed "name@file:line".
+	PrivateFuncs	map[string]Func			`json:"PrivateFuncs,omitempty"`
+	PublicFuncs		map[string]Func			`json:"PublicFuncs,omitempty"`
 	Relationships	Set						`json:"Relationships,omitempty"`
+	Consts			Set						`json:"Consts,omitempty"`
+	Pos				Position
+	VarPositions	map[string]Position		`json:"VarPositions,omitempty"`
+	Doc				string					`json:"Doc,omitempty"`
+	VarDocs			map[string]string		`json:"VarDocs,omitempty"`
+	Embeds			[]string				`json:"Embeds,omitempty"`
+}
+
+// Line of a declaration in the file, relative to the src directory.
+type Position struct {
+	File			string					`json:"File,omitempty"`
+	Line			int						`json:"Line,omitempty"`
+}
+
+type Param struct {
+	Name			string					`json:"Name,omitempty"`
+	Type			string
+}
+
+type Func struct {
+	Name			string
+	TypeParams		[]Param					`json:"TypeParams,omitempty"`
+	Params			[]Param
+	Results			[]Param					`json:"Results,omitempty"`
+	Variadic		bool					`json:"Variadic,omitempty"`
+	Goroutine		bool					`json:"Goroutine,omitempty"`
+	Relationships	Set						`json:"Relationships,omitempty"`
+	// "namespace.Class.Func" keys of the functions called, with --calls.
+	Calls			Set						`json:"Calls,omitempty"`
+	Pos				Position
+	Doc				string					`json:"Doc,omitempty"`
+	Body			[]string				`json:"-"`
 }
 
 type File struct {
 	Name		string
 	PkgName		string
 	Source		string						`json:"-"`
+	Raw			string						`json:"-"`
+	Doc			string						`json:"-"`
 	Imports		map[string]string
 	Types		map[string]Type
 	Package		*Package					`json:"-"`
@@ -47,12 +90,17 @@ type File struct {
 
 type Package struct {
 	Name		string
+	Module		string
+	// Directory of the module root relative to the src directory.
+	ModuleDir	string						`json:"ModuleDir,omitempty"`
+	Doc			string						`json:"Doc,omitempty"`
 	Files		map[string]File
 	TypeSet		map[string]string
 }
 
 type Parse struct {
 	Sources			map[string]string		`json:"-"`
+	Raw				map[string]string		`json:"-"`
 	Packages		map[string]Package
 	TypeMap			map[string]string
 }
@@ -60,6 +108,7 @@ type Parse struct {
 func Parser(sources []string) (*Parse, error) {
 	p := new(Parse)
 	p.Sources = make(map[string]string)
+	p.Raw = make(map[string]string)
 	p.Packages = make(map[string]Package)
 	p.TypeMap = make(map[string]string)
 	for _, source := range sources {
@@ -68,6 +117,7 @@ func Parser(sources []string) (*Parse, error) {
 			return nil, err
 		}
 
+		p.Raw[source] = string(data)
 		p.Sources[source] = util.StripComment(string(data))
 	}
 
@@ -76,13 +126,15 @@ func Parser(sources []string) (*Parse, error) {
 	}
 
 	p.PackageStructs()
+	p.GoroutineEntries()
+	p.GetPositions()
 
 	if util.Debug {
 		data, err := util.Dump(p)
 		if err != nil {
 			return nil, err
 		}
-		fmt.Println(string(data))
+		fmt.Fprintln(os.Stderr, string(data))
 	}
 	return p, nil
 }
@@ -118,6 +170,7 @@ func (p *Parse) GetPackages() error {
 
 		f := InitFile()
 		f.Source = source
+		f.Raw = p.Raw[file]
 		f.PkgName = packageName
 		f.Name = filename
 		
@@ -126,6 +179,7 @@ func (p *Parse) GetPackages() error {
 		if !exists {
 			pkg = InitPackage()
 			pkg.Name = packageName
+			pkg.Module, pkg.ModuleDir = module(directory, split[0], packageName)
 		}
 
 		f.Package = &pkg
@@ -138,6 +192,7 @@ func (p *Parse) GetPackages() error {
 			if err := f.GetImports(); err != nil {
 				return err
 			}
+			p.resolveImports(f)
 			
 			if err := f.GetStructs(); err != nil {
 				return err
@@ -157,6 +212,28 @@ func (p *Parse) GetPackages() error {
 	return nil
 }
 
+const MODULE_REGEX = "(?m)^module\\s+(\\S+)"
+
+// Module of the package in directory and the module's directory relative to
+// src: the module path of the nearest go.mod up to the src directory, or else
+// the top level directory of the package.
+func module(directory, root, packageName string) (string, string) {
+	re := regexp.MustCompile(MODULE_REGEX)
+	for dir := directory; strings.HasPrefix(dir + "/", root); dir = filepath.Dir(dir) {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			if matches := re.FindStringSubmatch(string(data)); matches != nil {
+				return matches[1], filepath.ToSlash(strings.Trim(strings.TrimPrefix(dir + "/", root), "/"))
+			}
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	top := strings.Split(packageName, "/")[0]
+	return top, top
+}
+
 const (
 	SINGLE_LINE_IMPORT_REGEX = "import(\\s)*\".*\"\n"
 	MULTI_LIINE_IMPORT_REGEX = "import.*?\\((.|\\s)*?\\)"
@@ -176,7 +253,6 @@ func (f *File) GetImports() error {
 	for _, line := range sImports {
 		ip := re.FindString(line)
 		ip = util.ReplaceAll(ip, "\"", "")
-		ip = util.ReplaceAll(ip, "../", "")
 		f.Imports[filepath.Base(ip)] = ip
 	}
 
@@ -191,7 +267,6 @@ func (f *File) GetImports() error {
 				return fmt.Errorf("Bad import parsing: %s", lines)
 			}
 			ip := util.ReplaceAll(split[1], "\"", "")
-			ip = util.ReplaceAll(ip, "../", "")
 			if split[0] == "" {
 				f.Imports[filepath.Base(ip)] = ip
 			} else {
@@ -202,6 +277,69 @@ func (f *File) GetImports() error {
 	return nil
 }
 
+// Name of the parsed package imported as ip from the package in dir, or ""
+// when it isn't parsed: relative imports, eg. "../store", paths under src or
+// paths in a module.
+func (p *Parse) Resolve(ip, dir string) string {
+	if strings.HasPrefix(ip, ".") {
+		ip = path.Join(dir, ip)
+	}
+	if _, exists := p.Packages[ip]; exists {
+		return ip
+	}
+
+	// The path within the module is the directory below the module's.
+	candidates := make(Set)
+	for _, pkg := range p.Packages {
+		if pkg.Module == "" || (ip != pkg.Module && !strings.HasPrefix(ip, pkg.Module + "/")) {
+			continue
+		}
+		rest := strings.TrimPrefix(ip, pkg.Module)
+		if name := strings.TrimPrefix(path.Join(pkg.ModuleDir, rest), "/"); name != "" {
+			if found, exists := p.Packages[name]; exists && found.Module == pkg.Module {
+				return name
+			}
+		}
+		if rest != "" && strings.HasSuffix("/" + pkg.Name, rest) {
+			candidates[pkg.Name] = struct{}{}
+		}
+		// The module's root package holds every other package of the module.
+		if rest == "" && p.isRoot(pkg) {
+			candidates[pkg.Name] = struct{}{}
+		}
+	}
+	// Without a go.mod the module directory is a guess, so a path matching
+	// the end of a single package name is taken as that package.
+	if len(candidates) == 1 {
+		for name, _ := range candidates {
+			return name
+		}
+	}
+	return ""
+}
+
+func (p *Parse) isRoot(root Package) bool {
+	for _, pkg := range p.Packages {
+		if pkg.Module == root.Module && pkg.Name != root.Name && !strings.HasPrefix(pkg.Name, root.Name + "/") {
+			return false
+		}
+	}
+	return true
+}
+
+// Imports of parsed packages are recorded by the package name, its path
+// relative to the src directory, so keys such as "store/sql.DB" match the
+// types of that package.
+func (p *Parse) resolveImports(f File) {
+	for k, ip := range f.Imports {
+		if name := p.Resolve(ip, f.Package.Name); name != "" {
+			f.Imports[k] = name
+		} else {
+			f.Imports[k] = util.ReplaceAll(ip, "../", "")
+		}
+	}
+}
+
 const (
 	STRUCT_REGEX = "type\\s.*?\\sstruct\\s?{"
 	TYPE_REGEX = "\\s?type\\s"
@@ -211,9 +349,12 @@ func InitType() Type {
 	var t Type
 	t.PrivateVars = make(map[string]string)
 	t.PublicVars = make(map[string]string)
-	t.PrivateFuncs = make(Set)
-	t.PublicFuncs = make(Set)
+	t.PrivateFuncs = make(map[string]Func)
+	t.PublicFuncs = make(map[string]Func)
 	t.Relationships = make(Set)
+	t.Consts = make(Set)
+	t.VarPositions = make(map[string]Position)
+	t.VarDocs = make(map[string]string)
 	return t
 }
 
@@ -237,10 +378,19 @@ func (f *File) GetStructs() error {
 		} 
 
 		t := InitType()
+		if i := strings.Index(split[0], "["); i > 0 {
+			end := closing(nLine, i)
+			if end < 0 {
+				return fmt.Errorf("Type parameters not closed: %s\n", nLine)
+			}
+			t.TypeParams = ParseParams(nLine[i+1:end])
+			split = []string{nLine[:i], strings.TrimSpace(nLine[end+1:])}
+		}
 		t.Name = split[0]
 		f.Package.TypeSet[t.Name] = t.Name
 		
-		if strings.HasPrefix(split[1], "struct") {
+		switch {
+		case strings.HasPrefix(split[1], "struct"):
 			t.Type = "struct"
 
 			if i+1 >= len(lines) {
@@ -250,26 +400,69 @@ func (f *File) GetStructs() error {
 				if field == "}" {
 					break
 				}
-				definition := re2.Split(field, 3)
-				if len(definition) < 2 {
-					return fmt.Errorf("Definition not formatted correctly. Format: \"NAME DEFINITION <optional tag>\"): %s\n", field)
-				}
-				if definition[0] == "" {
-					definition = definition[1:]
+				// Indented comments leave whitespace only lines.
+				field = strings.TrimSpace(field)
+				if field == "" {
+					continue
 				}
-				name := re2.ReplaceAllString(definition[0], "")
-				typ := re2.ReplaceAllString(definition[1], "")
-				if len(name) < 1 {
-					return errors.New("Field name empty")
+				definition := re2.Split(field, 3)
+				// Embedded fields are named after their type.
+				if len(definition) == 1 || strings.HasPrefix(definition[1], "`") {
+					name := EmbeddedName(definition[0])
+					if name == "" {
+						return fmt.Errorf("Definition not formatted correctly. Format: \"NAME DEFINITION <optional tag>\"): %s\n", field)
+					}
+					t.Embeds = append(t.Embeds, definition[0])
+					definition = []string{name, definition[0]}
 				}
+				name := definition[0]
+				typ := definition[1]
 				if strings.ToUpper(string(name[0])) == string(name[0]) {
 					t.PublicVars[name] = typ
 				} else {
 					t.PrivateVars[name] = typ
 				}
 			}
-			
-		} else {
+
+		case strings.HasPrefix(split[1], "interface") && strings.HasSuffix(split[1], "{"):
+			t.Type = split[1]
+			// Braces are counted as the "}" of a local interface is indented.
+			// Methods and embeds are the lines at depth 1.
+			depth := 1
+			for j := i + 1; j < len(lines); j++ {
+				line := strings.TrimSpace(lines[j])
+				for parenDepth(line) > 0 && j+1 < len(lines) {
+					j++
+					line += " " + strings.TrimSpace(lines[j])
+				}
+				before := depth
+				depth += strings.Count(line, "{") - strings.Count(line, "}")
+				if depth <= 0 {
+					break
+				}
+				if before != 1 || depth != 1 {
+					continue
+				}
+				line = flatten(line)
+				switch {
+				case strings.Contains(line, "("):
+					fn, err := ParseFunc(line)
+					if err != nil {
+						util.PrintErr(err)
+						continue
+					}
+					if strings.ToUpper(fn.Name[:1]) == fn.Name[:1] {
+						t.PublicFuncs[fn.Name] = fn
+					} else {
+						t.PrivateFuncs[fn.Name] = fn
+					}
+				// Unions of type constraints are neither.
+				case EmbeddedName(line) != "":
+					t.Embeds = append(t.Embeds, line)
+				}
+			}
+
+		default:
 			t.Type = split[1]
 		}
 		f.Types[t.Name] = t
@@ -277,26 +470,341 @@ func (f *File) GetStructs() error {
 	return nil
 }
 
+const EMBEDDED_REGEX = "^\\*?([A-Za-z_][A-Za-z0-9_]*\\.)?([A-Za-z_][A-Za-z0-9_]*)(\\[.*\\])?$"
+
+// Field name of an embedded type, eg. "Reader" for "*io.Reader". Empty when
+// typ isn't a type name.
+func EmbeddedName(typ string) string {
+	re := regexp.MustCompile(EMBEDDED_REGEX)
+	matches := re.FindStringSubmatch(typ)
+	if matches == nil {
+		return ""
+	}
+	return matches[2]
+}
+
 const (
 	FUNC_REGEX = "func\\s.*?{\n"
 	FUNC_END_REGEX = "\\s{"
 	FUNC_STRUCT_REGEX = "func\\s\\(.*?\\)\\s"
 	FUNC_START_REGEX = "func\\s"
 	STRUCT_REGEX2 = "(.*?\\s\\*?(.*?))\\s"
+	IDENT_REGEX = "[A-Za-z_][A-Za-z0-9_]*(\\.[A-Za-z_][A-Za-z0-9_]*)?"
+	BAD_SIGNATURE_ERR = "Couldn't parse function signature: %s"
 )
 
+// Type keywords that can start a parameter type followed by a space, so a
+// leading "chan int" isn't mistaken for a parameter named chan.
+var typeKeywords = map[string]bool{
+	"chan": true,
+	"func": true,
+	"map": true,
+	"struct": true,
+	"interface": true,
+}
+
+// Signatures wrapped by gofmt are joined back onto the line holding the func
+// keyword so the rest of the parser can keep working line by line.
+func JoinSignatures(lines []string) []string {
+	joined := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "func ") {
+			depth := parenDepth(line)
+			for depth > 0 && i+1 < len(lines) {
+				i++
+				line += " " + strings.TrimSpace(lines[i])
+				depth += parenDepth(lines[i])
+			}
+			line = flatten(line)
+		}
+		joined = append(joined, line)
+	}
+	return joined
+}
+
+// Signature joined from wrapped lines without the whitespace and trailing
+// commas gofmt leaves inside the parentheses.
+func flatten(line string) string {
+	re := regexp.MustCompile("\\(\\s+")
+	re2 := regexp.MustCompile(",?\\s+\\)")
+	line = re.ReplaceAllString(line, "(")
+	return re2.ReplaceAllString(line, ")")
+}
+
+func parenDepth(line string) int {
+	return strings.Count(line, "(") - strings.Count(line, ")")
+}
+
+// Index of the bracket closing the one opened at s[start], or -1.
+func closing(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Split on sep, ignoring anything nested inside brackets.
+func splitTopLevel(s string, sep byte) []string {
+	split := make([]string, 0)
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case sep:
+			if depth == 0 {
+				split = append(split, strings.TrimSpace(s[last:i]))
+				last = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[last:]); rest != "" {
+		split = append(split, rest)
+	}
+	return split
+}
+
+func splitNamed(param string) (string, string, bool) {
+	i := strings.IndexAny(param, " \t")
+	if i < 0 {
+		return "", "", false
+	}
+	name := param[:i]
+	re := regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+	if !re.MatchString(name) || typeKeywords[name] {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(param[i:]), true
+}
+
+// Parse a parameter, result or type parameter list. Grouped names such as
+// "a, b int" share the type of the last name in the group.
+func ParseParams(list string) []Param {
+	params := make([]Param, 0)
+	pieces := splitTopLevel(list, ',')
+	named := false
+	for _, piece := range pieces {
+		if _, _, ok := splitNamed(piece); ok {
+			named = true
+		}
+	}
+
+	for _, piece := range pieces {
+		if !named {
+			params = append(params, Param{Type: piece})
+			continue
+		}
+		if name, typ, ok := splitNamed(piece); ok {
+			params = append(params, Param{Name: name, Type: typ})
+		} else {
+			params = append(params, Param{Name: piece})
+		}
+	}
+
+	for i := len(params) - 2; i >= 0; i-- {
+		if params[i].Type == "" {
+			params[i].Type = params[i+1].Type
+		}
+	}
+	return params
+}
+
+// Parse a function declaration such as
+// "func (s *S) Get(ctx Context, id string) (*User, error)".
+func ParseFunc(sig string) (Func, error) {
+	var fn Func
+	fn.Relationships = make(Set)
+	s := strings.TrimSpace(sig)
+	s = strings.TrimSpace(strings.TrimPrefix(s, "func"))
+
+	if strings.HasPrefix(s, "(") {
+		end := closing(s, 0)
+		if end < 0 {
+			return fn, fmt.Errorf(BAD_SIGNATURE_ERR, sig)
+		}
+		s = strings.TrimSpace(s[end+1:])
+	}
+
+	i := strings.IndexAny(s, "[(")
+	if i < 1 {
+		return fn, fmt.Errorf(BAD_SIGNATURE_ERR, sig)
+	}
+	fn.Name = s[:i]
+	s = s[i:]
+
+	if strings.HasPrefix(s, "[") {
+		end := closing(s, 0)
+		if end < 0 {
+			return fn, fmt.Errorf(BAD_SIGNATURE_ERR, sig)
+		}
+		fn.TypeParams = ParseParams(s[1:end])
+		s = s[end+1:]
+	}
+
+	end := closing(s, 0)
+	if !strings.HasPrefix(s, "(") || end < 0 {
+		return fn, fmt.Errorf(BAD_SIGNATURE_ERR, sig)
+	}
+	fn.Params = ParseParams(s[1:end])
+	if n := len(fn.Params); n > 0 && strings.HasPrefix(fn.Params[n-1].Type, "...") {
+		fn.Variadic = true
+	}
+
+	results := strings.TrimSpace(s[end+1:])
+	if strings.HasPrefix(results, "(") {
+		end = closing(results, 0)
+		if end < 0 {
+			return fn, fmt.Errorf(BAD_SIGNATURE_ERR, sig)
+		}
+		fn.Results = ParseParams(results[1:end])
+	} else if results != "" {
+		fn.Results = []Param{Param{Type: results}}
+	}
+	return fn, nil
+}
+
+// Types named in a function's params and results.
+func (f *File) SignatureUses(fn Func) []string {
+	uses := make([]string, 0)
+	for _, params := range [][]Param{fn.Params, fn.Results} {
+		for _, param := range params {
+			uses = append(uses, f.TypeUses(param.Type)...)
+		}
+	}
+	return uses
+}
+
+func (f *File) TypeUses(typ string) []string {
+	uses := make([]string, 0)
+	re := regexp.MustCompile(IDENT_REGEX)
+	for _, ident := range re.FindAllString(typ, -1) {
+		split := strings.Split(ident, ".")
+		if len(split) == 2 {
+			if ip, exists := f.Imports[split[0]]; exists {
+				uses = append(uses, ip + "." + split[1])
+			}
+			continue
+		}
+		if v, exists := f.Package.TypeSet[ident]; exists {
+			uses = append(uses, f.Package.Name + "." + v)
+		}
+	}
+	return uses
+}
+
+// Add a parsed function to typ, collecting the body that starts at lines[i].
+// Returns the body and the index of the line after the function.
+func (f *File) addFunc(typ string, fn Func, lines []string, i int) (string, []string, int) {
+	body := make([]string, 0)
+	j := i
+	for _, cnt := range lines[j:] {
+		i++
+		if cnt == "}" {
+			break
+		}
+		body = append(body, cnt)
+	}
+	fn.Body = body
+
+	funcs := f.Types[typ].PrivateFuncs
+	if strings.ToUpper(string(fn.Name[0])) == string(fn.Name[0]) {
+		funcs = f.Types[typ].PublicFuncs
+	}
+	key := funcKey(funcs, fn.Name, func(Func) bool {
+		return false
+	})
+	funcs[key] = fn
+	return key, body, i
+}
+
+// "name@file:line" key of a func whose name is declared more than once.
+func (fn Func) PositionKey() string {
+	return fmt.Sprintf("%s@%s:%d", fn.Name, fn.Pos.File, fn.Pos.Line)
+}
+
+// Key of the first func named name that match accepts: "name", then "name#2",
+// "name#3"... for the repeated names, eg. init, of a file in source order.
+// Past the last one it's the key for the next func of that name.
+func funcKey(funcs map[string]Func, name string, match func(Func) bool) string {
+	key := name
+	for n := 2; ; n++ {
+		fn, exists := funcs[key]
+		if !exists || match(fn) {
+			return key
+		}
+		key = name + "#" + strconv.Itoa(n)
+	}
+}
+
+// Key the funcs of each type whose name the package declares more than once
+// by their position, so that the types of its files merge without losing any.
+func (p *Parse) keyRepeatedFuncs(pkg Package) {
+	count := make(map[string]int)
+	for _, f := range pkg.Files {
+		for _, t := range f.Types {
+			for _, funcs := range []map[string]Func{t.PublicFuncs, t.PrivateFuncs} {
+				for _, fn := range funcs {
+					count[t.Name + "." + fn.Name]++
+				}
+			}
+		}
+	}
+
+	for _, f := range pkg.Files {
+		for _, t := range f.Types {
+			for _, funcs := range []map[string]Func{t.PublicFuncs, t.PrivateFuncs} {
+				for key, fn := range funcs {
+					if count[t.Name + "." + fn.Name] < 2 || strings.Contains(key, "@") {
+						continue
+					}
+					delete(funcs, key)
+					funcs[fn.PositionKey()] = fn
+				}
+			}
+		}
+	}
+}
+
+// Record what a function uses on both the function and its type.
+func (f *File) funcUses(typ, key string, body []string) {
+	fn, exists := f.Types[typ].PublicFuncs[key]
+	if !exists {
+		fn = f.Types[typ].PrivateFuncs[key]
+	}
+
+	uses := f.SignatureUses(fn)
+	for _, line := range body {
+		uses = append(uses, f.Uses(line)...)
+	}
+	for _, use := range uses {
+		fn.Relationships[use] = struct{}{}
+		f.Types[typ].Relationships[use] = struct{}{}
+	}
+}
+
 func (f *File) GetFunctions() error {
 	source := util.ReplaceAll(f.Source, "\n\n", "\n")
-	lines := strings.Split(source, "\n")
+	lines := JoinSignatures(strings.Split(source, "\n"))
 
 	re := regexp.MustCompile(FUNC_REGEX)
 	re2 := regexp.MustCompile(FUNC_END_REGEX)
 	re3 := regexp.MustCompile(FUNC_STRUCT_REGEX)
 	re4 := regexp.MustCompile(FUNC_START_REGEX)
-	re5 := regexp.MustCompile(STRUCT_REGEX2)
 
 	global := make([]string, 0)
-	functionLines := make(map[string][]string)
+	functionLines := make(map[string]map[string][]string)
 	
 	// Get struct functions
 	for i := 0; i < len(lines); {
@@ -315,65 +823,73 @@ func (f *File) GetFunctions() error {
 		}
 
 		noFuncStr := re4.ReplaceAllString(funcStr, "")
-		matches := re5.FindStringSubmatch(noFuncStr)
-		if len(matches) < 3 {
+		end := closing(noFuncStr, 0)
+		if end < 0 {
+			return fmt.Errorf("Failed to get struct for function: %s", line)
+		}
+		receiver := noFuncStr[1:end]
+		if j := strings.Index(receiver, "["); j >= 0 {
+			receiver = receiver[:j]
+		}
+		split := strings.Fields(receiver)
+		if len(split) == 0 {
 			return fmt.Errorf("Failed to get struct for function: %s", line)
 		}
-		typ := matches[2][:len(matches[2])-1]
+		typ := strings.TrimPrefix(split[len(split)-1], "*")
 		if _, exists := f.Types[typ]; !exists {
 			newType := InitType()
 			newType.Name = typ
 			f.Types[typ] = newType
 		}
-		
-		funcDef := re3.ReplaceAllString(funcStr, "")
-		if len(funcDef) < 1 {
-			return fmt.Errorf("No function name: %s")
-		}
 
-		if strings.ToUpper(string(funcDef[0])) == string(funcDef[0]) {
-			f.Types[typ].PublicFuncs[funcDef] = struct{}{}
-		} else {
-			f.Types[typ].PrivateFuncs[funcDef] = struct{}{}
+		fn, err := ParseFunc(funcStr)
+		if err != nil {
+			return err
 		}
 
-		j := i
-		for _, cnt := range lines[j:] {
-			i++
-			if cnt == "}" {
-				break
-			}
-			functionLines[typ] = append(functionLines[typ], cnt)
+		if _, exists := functionLines[typ]; !exists {
+			functionLines[typ] = make(map[string][]string)
 		}
+		key, body, next := f.addFunc(typ, fn, lines, i)
+		functionLines[typ][key], i = body, next
 	}
 
 	if err := f.Global(global); err != nil {
 		return err
 	}
 
-	for typ, lines := range functionLines {
-		for _, line := range lines {
-			uses := f.Uses(line)
-			for _, use := range uses {
-				f.Types[typ].Relationships[use] = struct{}{}
-			}
+	for typ, funcs := range functionLines {
+		for name, body := range funcs {
+			f.funcUses(typ, name, body)
 		}
 	}
 	return nil
 }
 
 const (
-	CONST_VAR_MULTI_REGEX = "(var|const)\\s?\\("
+	CONST_VAR_MULTI_REGEX = "^(var|const)\\s?\\("
 	CONST_VAR_SINGLE_REGEX = "(var|const)\\s?.*?"
 	CONST_VAR_REGEX = "(var|const)\\s?"
 	VAR_STRUCT_REGEX = "var\\s+.*?struct"
 )
 
+// Name of the <Pkg>Global type of the package at path pkg. It's made from the
+// last path element without dots or dashes, eg. "YamlV2Global" for
+// "gopkg.in/yaml.v2", as "." and "/" split the "namespace.Class" keys.
+func GlobalName(pkg string) string {
+	words := strings.FieldsFunc(path.Base(pkg), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for i, word := range words {
+		words[i] = strings.Title(word)
+	}
+	return strings.Join(words, "") + "Global"
+}
+
 func (f *File) Global(lines []string) error {
-	typ := strings.Title(f.PkgName) + "Global" 
+	typ := GlobalName(f.PkgName)
 	re := regexp.MustCompile(FUNC_REGEX)
 	re2 := regexp.MustCompile(FUNC_END_REGEX)
-	re4 := regexp.MustCompile(FUNC_START_REGEX)
 
 	if _, exists := f.Types[typ]; !exists {
 		t := InitType()
@@ -383,33 +899,22 @@ func (f *File) Global(lines []string) error {
 	}
 
 	variables := make([]string, 0)
-	functionLines := make([]string, 0)
+	functionLines := make(map[string][]string)
 	for i := 0; i < len(lines); {
 		line := lines[i]
 
-		if !re.Match([]byte(line + "\n")) {
+		if !strings.HasPrefix(line, "func ") || !re.Match([]byte(line + "\n")) {
 			variables = append(variables, line)
 			i++
 			continue
 		}
 
-		funcStr := re2.ReplaceAllString(line, "")
-		noFuncStr := re4.ReplaceAllString(funcStr, "")
-
-		if strings.ToUpper(string(noFuncStr[0])) == string(noFuncStr[0]) {
-			f.Types[typ].PublicFuncs[noFuncStr] = struct{}{}
-		} else {
-			f.Types[typ].PrivateFuncs[noFuncStr] = struct{}{}
-		}
-
-		j := i
-		for _, cnt := range lines[j:] {
-			i++
-			if cnt == "}" {
-				break
-			}
-			functionLines = append(functionLines, cnt)	
+		fn, err := ParseFunc(re2.ReplaceAllString(line, ""))
+		if err != nil {
+			return err
 		}
+		key, body, next := f.addFunc(typ, fn, lines, i)
+		functionLines[key], i = body, next
 	}
 
 	noMulti := make([]string, 0)
@@ -426,6 +931,7 @@ func (f *File) Global(lines []string) error {
 		if j >= len(variables) {
 			return fmt.Errorf("Couldn't parse const in %s", f.PkgName)
 		}
+		isConst := strings.HasPrefix(strings.TrimSpace(line), "const")
 		for _, cnst := range variables[j:] {
 			i++
 			if cnst == ")" {
@@ -439,11 +945,17 @@ func (f *File) Global(lines []string) error {
 			if constant == "" {
 				constant = split[1]
 			}
-			cType := split[2]
-			if cType == "=" {
-				cType = ""
+			if constant == "" {
+				continue
+			}
+			var cType string
+			if len(split) > 2 && split[2] != "=" {
+				cType = split[2]
 			}
 
+			if isConst {
+				f.Types[typ].Consts[constant] = struct{}{}
+			}
 			f.Package.TypeSet[constant] = typ
 			if strings.ToUpper(string(constant[0])) == string(constant[0]) {
 				f.Types[typ].PublicVars[constant] = cType
@@ -456,7 +968,6 @@ func (f *File) Global(lines []string) error {
 	
 	re = regexp.MustCompile(CONST_VAR_SINGLE_REGEX)
 	re2 = regexp.MustCompile(CONST_VAR_REGEX)
-	re4 = regexp.MustCompile(VAR_STRUCT_REGEX)
 
 	for _, line := range noMulti {
 		if !re.Match([]byte(line)) {
@@ -514,12 +1025,23 @@ func (f *File) Global(lines []string) error {
 				}
 			}
 			
+		} else if strings.HasPrefix(line, "var ") || strings.HasPrefix(line, "const ") {
+			split := strings.Fields(line)
+			if len(split) > 2 && split[2] != "=" {
+				variables[split[1]] = split[2]
+			} else if len(split) > 1 {
+				variables[split[1]] = ""
+			}
 		}
 
+		isConst := strings.HasPrefix(strings.TrimSpace(line), "const")
 		for k, v := range variables {
 			if len(k) < 1 {
 				return errors.New("Variable name empty. Bad parsing.")
 			}
+			if isConst {
+				f.Types[typ].Consts[k] = struct{}{}
+			}
 			f.Package.TypeSet[k] = typ
 			if strings.ToUpper(string(k[0])) == string(k[0]) {
 				f.Types[typ].PublicVars[k] = v
@@ -529,11 +1051,8 @@ func (f *File) Global(lines []string) error {
 		}
 	}
 
-	for _, line := range functionLines {
-		uses := f.Uses(line)
-		for _, use := range uses {
-			f.Types[typ].Relationships[use] = struct{}{}
-		}
+	for name, body := range functionLines {
+		f.funcUses(typ, name, body)
 	}
 	return nil
 }
@@ -546,7 +1065,7 @@ func (f *File) Uses(line string) []string {
 		if len(matches) < 2 {
 			continue
 		}
-		uses = append(uses, strings.Replace(matches[1], k, filepath.Base(v), 1))
+		uses = append(uses, v + strings.TrimPrefix(matches[1], k))
 	}
 
 	for k, v := range f.Package.TypeSet {
@@ -563,11 +1082,199 @@ func (p *Parse) PackageStructs() {
 	for name, pkg := range p.Packages {
 		for _, f := range pkg.Files {
 			for typ, t := range f.Types {
-				p.TypeMap[filepath.Base(name) + "." + typ] = typ
+				p.TypeMap[name + "." + typ] = typ
 				if t.Type == "global" {
 					
 				}
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+const GO_REGEX = "\\bgo\\s+([A-Za-z_][A-Za-z0-9_]*)(\\.([A-Za-z_][A-Za-z0-9_]*))?\\s*(\\[.*?\\])?\\("
+
+// Mark every function started with "go f(...)" as a goroutine entry point.
+// Calls on a variable, eg. "go s.loop()", mark every method of that name in the
+// package as the receiver's type isn't known.
+func (p *Parse) GoroutineEntries() {
+	re := regexp.MustCompile(GO_REGEX)
+	for _, pkg := range p.Packages {
+		for _, f := range pkg.Files {
+			for _, matches := range re.FindAllStringSubmatch(f.Source, -1) {
+				if matches[3] == "" {
+					p.markGoroutine(pkg.Name, GlobalName(pkg.Name), matches[1])
+				} else if ip, exists := f.Imports[matches[1]]; exists {
+					p.markGoroutine(ip, GlobalName(ip), matches[3])
+				} else {
+					p.markGoroutine(pkg.Name, "", matches[3])
+				}
+			}
+		}
+	}
+}
+
+// Mark function name of typ in package pkgName. An empty typ matches methods
+// of every non-global type.
+func (p *Parse) markGoroutine(pkgName, typ, name string) {
+	pkg, exists := p.Packages[pkgName]
+	if !exists {
+		return
+	}
+
+	for _, f := range pkg.Files {
+		for _, t := range f.Types {
+			if t.Name != typ && (typ != "" || t.Type == "global") {
+				continue
+			}
+			for _, funcs := range []map[string]Func{t.PublicFuncs, t.PrivateFuncs} {
+				if fn, exists := funcs[name]; exists {
+					fn.Goroutine = true
+					funcs[name] = fn
+				}
+			}
+		}
+	}
+}
+
+const (
+	POS_FUNC_REGEX = "^func\\s*(\\(([^)]*)\\))?\\s*([A-Za-z_][A-Za-z0-9_]*)"
+	POS_IDENT_REGEX = "^[A-Za-z_][A-Za-z0-9_]*"
+)
+
+// Find the line and doc comment of every type, function, field and package
+// var. Runs on the raw source as the parsed source has comments and blank
+// lines removed.
+// The package doc comment comes from doc.go when there is one.
+func (p *Parse) GetPositions() {
+	for name, pkg := range p.Packages {
+		for filename, f := range pkg.Files {
+			f.GetPositions()
+			pkg.Files[filename] = f
+			if f.Doc != "" && (pkg.Doc == "" || filepath.Base(filename) == "doc.go") {
+				pkg.Doc = f.Doc
+			}
+		}
+		p.keyRepeatedFuncs(pkg)
+		p.Packages[name] = pkg
+	}
+}
+
+func (f *File) GetPositions() {
+	global := GlobalName(f.PkgName)
+	re := regexp.MustCompile(POS_FUNC_REGEX)
+	re2 := regexp.MustCompile(POS_IDENT_REGEX)
+	block := ""
+	comment := make([]string, 0)
+	inComment := false
+	for i, line := range strings.Split(f.Raw, "\n") {
+		pos := Position{File: f.Name, Line: i + 1}
+		trimmed := strings.TrimSpace(line)
+
+		// Comment lines directly above a declaration are its doc comment.
+		switch {
+		case inComment:
+			inComment = !strings.HasSuffix(trimmed, "*/")
+			comment = append(comment, strings.TrimSpace(strings.TrimSuffix(trimmed, "*/")))
+			continue
+		case strings.HasPrefix(trimmed, "//"):
+			comment = append(comment, strings.TrimSpace(strings.TrimPrefix(trimmed, "//")))
+			continue
+		case strings.HasPrefix(trimmed, "/*"):
+			inComment = !strings.HasSuffix(trimmed, "*/")
+			trimmed = strings.TrimSuffix(strings.TrimPrefix(trimmed, "/*"), "*/")
+			comment = append(comment, strings.TrimSpace(trimmed))
+			continue
+		}
+		doc := strings.TrimSpace(strings.Join(comment, "\n"))
+		comment = comment[:0]
+
+		switch {
+		case strings.HasPrefix(line, "package "):
+			f.Doc = doc
+			f.setPos(global, pos, "")
+		case strings.HasPrefix(line, "var (") || strings.HasPrefix(line, "const ("):
+			block = global
+		case strings.HasPrefix(line, "var ") || strings.HasPrefix(line, "const "):
+			names := strings.SplitN(trimmed, " ", 2)[1]
+			for _, name := range strings.Split(names, ",") {
+				f.setVarPos(global, re2.FindString(strings.TrimSpace(name)), pos, doc)
+			}
+		case strings.HasPrefix(line, "type "):
+			name := re2.FindString(strings.TrimSpace(strings.TrimPrefix(line, "type ")))
+			f.setPos(name, pos, doc)
+			if strings.HasSuffix(trimmed, "{") {
+				block = name
+			}
+		case strings.HasPrefix(line, "func "):
+			matches := re.FindStringSubmatch(line)
+			if matches == nil {
+				continue
+			}
+			typ := global
+			if receiver := matches[2]; receiver != "" {
+				if j := strings.Index(receiver, "["); j >= 0 {
+					receiver = receiver[:j]
+				}
+				split := strings.Fields(receiver)
+				typ = strings.TrimPrefix(split[len(split)-1], "*")
+			}
+			f.setFuncPos(typ, matches[3], pos, doc)
+		case line == ")" || line == "}":
+			block = ""
+		case block != "":
+			name := re2.FindString(trimmed)
+			if fields := strings.Fields(trimmed); len(fields) == 1 || (len(fields) > 1 && strings.HasPrefix(fields[1], "`")) {
+				if embedded := EmbeddedName(fields[0]); embedded != "" {
+					name = embedded
+				}
+			}
+			f.setVarPos(block, name, pos, doc)
+			// Interface methods.
+			f.setFuncPos(block, name, pos, doc)
+		}
+	}
+}
+
+func (f *File) setPos(typ string, pos Position, doc string) {
+	if t, exists := f.Types[typ]; exists && t.Pos.Line == 0 {
+		t.Pos = pos
+		t.Doc = doc
+		f.Types[typ] = t
+	}
+}
+
+func (f *File) setVarPos(typ, name string, pos Position, doc string) {
+	t, exists := f.Types[typ]
+	if !exists || name == "" {
+		return
+	}
+	if _, exists := t.VarPositions[name]; exists {
+		return
+	}
+	_, private := t.PrivateVars[name]
+	_, public := t.PublicVars[name]
+	if private || public {
+		t.VarPositions[name] = pos
+		if doc != "" {
+			t.VarDocs[name] = doc
+		}
+	}
+}
+
+func (f *File) setFuncPos(typ, name string, pos Position, doc string) {
+	t, exists := f.Types[typ]
+	if !exists {
+		return
+	}
+	// Repeated names are positioned in source order.
+	for _, funcs := range []map[string]Func{t.PublicFuncs, t.PrivateFuncs} {
+		key := funcKey(funcs, name, func(fn Func) bool {
+			return fn.Pos.Line == 0
+		})
+		if fn, exists := funcs[key]; exists {
+			fn.Pos = pos
+			fn.Doc = doc
+			funcs[key] = fn
+		}
+	}
+}
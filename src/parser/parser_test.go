@@ -0,0 +1,292 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Parse the .go files under dir, a path relative to the test with a "src"
+// directory in it.
+func parseDir(t *testing.T, dir string) *Parse {
+	sources := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && filepath.Ext(path) == ".go" {
+			sources = append(sources, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Parser(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestResolve(t *testing.T) {
+	p := parseDir(t, "testdata/resolve/src")
+	tests := []struct {
+		ip, dir, want string
+	}{
+		{"example.com/mod/store", "app", "store"},
+		{"example.com/mod/internal/store", "app", "internal/store"},
+		{"../store", "internal/store", "internal/store"},
+		{"../../store", "internal/store", "store"},
+		{"store", "app", "store"},
+		{"example.com/mod/other", "app", ""},
+		{"fmt", "app", ""},
+	}
+	for _, test := range tests {
+		if got := p.Resolve(test.ip, test.dir); got != test.want {
+			t.Errorf("Resolve(%q, %q) = %q, want %q", test.ip, test.dir, got, test.want)
+		}
+	}
+
+	imports := p.Packages["app"].Files["app/app.go"].Imports
+	if imports["store"] != "store" || imports["istore"] != "internal/store" {
+		t.Errorf("imports of app = %v", imports)
+	}
+}
+
+func TestGlobalName(t *testing.T) {
+	tests := []struct {
+		pkg, want string
+	}{
+		{"app", "AppGlobal"},
+		{"store/sql", "SqlGlobal"},
+		{"github.com/o/mod/app", "AppGlobal"},
+		{"gopkg.in/yaml.v2", "YamlV2Global"},
+		{"my_pkg", "My_pkgGlobal"},
+	}
+	for _, test := range tests {
+		if got := GlobalName(test.pkg); got != test.want {
+			t.Errorf("GlobalName(%q) = %q, want %q", test.pkg, got, test.want)
+		}
+	}
+}
+
+// Keys of types in packages under a dotted module path split into namespace
+// and class at their last ".".
+func TestDottedModulePath(t *testing.T) {
+	p := parseDir(t, "testdata/dotted/src")
+	want := []string{
+		"github.com/o/mod/app.App",
+		"github.com/o/mod/app.AppGlobal",
+		"github.com/o/mod/store.Store",
+		"github.com/o/mod/store.StoreGlobal",
+		"github.com/o/mod/internal/store.Cache",
+		"github.com/o/mod/internal/store.StoreGlobal",
+	}
+	for _, key := range want {
+		if _, exists := p.TypeMap[key]; !exists {
+			t.Errorf("TypeMap has no %s: %v", key, p.TypeMap)
+		}
+	}
+	for key, _ := range p.TypeMap {
+		i := strings.LastIndex(key, ".")
+		if _, exists := p.Packages[key[:i]]; !exists {
+			t.Errorf("%s doesn't split into a package and a class", key)
+		}
+	}
+
+	global := p.Packages["github.com/o/mod/store"].Files["github.com/o/mod/store/store.go"].Types["StoreGlobal"]
+	if _, exists := global.PublicVars["Default"]; !exists {
+		t.Errorf("StoreGlobal has no Default: %v", global.PublicVars)
+	}
+	if _, exists := global.PublicFuncs["Open"]; !exists {
+		t.Errorf("StoreGlobal has no Open: %v", global.PublicFuncs)
+	}
+
+	rs := p.Packages["github.com/o/mod/app"].Files["github.com/o/mod/app/app.go"].Types["App"].Relationships
+	for _, key := range []string{"github.com/o/mod/store.Store", "github.com/o/mod/internal/store.Cache"} {
+		if _, exists := rs[key]; !exists {
+			t.Errorf("App has no relationship to %s: %v", key, rs)
+		}
+	}
+}
+
+// Every init of a package is kept, keyed by its position, with its own doc.
+func TestRepeatedInit(t *testing.T) {
+	p := parseDir(t, "testdata/inits/src")
+	pkg := p.Packages["app"]
+	inits := make(map[string]Func)
+	for _, f := range pkg.Files {
+		for key, fn := range f.Types["AppGlobal"].PrivateFuncs {
+			if fn.Name == "init" {
+				inits[key] = fn
+			}
+		}
+	}
+
+	want := map[string]string{
+		"init@app/a.go:9": "Sets first.",
+		"init@app/a.go:18": "Sets second.",
+		"init@app/b.go:5": "",
+	}
+	if len(inits) != len(want) {
+		t.Fatalf("inits = %v, want %d", inits, len(want))
+	}
+	for key, doc := range want {
+		fn, exists := inits[key]
+		if !exists {
+			t.Errorf("no %s in %v", key, inits)
+			continue
+		}
+		if fn.Doc != doc {
+			t.Errorf("%s doc = %q, want %q", key, fn.Doc, doc)
+		}
+	}
+
+	a := pkg.Files["app/a.go"].Types["AppGlobal"]
+	if fn, exists := a.PublicFuncs["Run"]; !exists || fn.Pos.Line != 13 || len(fn.Params) != 1 {
+		t.Errorf("Run = %+v", fn)
+	}
+}
+
+func TestSplitTopLevel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{}},
+		{"a int", []string{"a int"}},
+		{"a, b int, c string", []string{"a", "b int", "c string"}},
+		{"m map[string]int, f func(a, b int) (int, error)", []string{"m map[string]int", "f func(a, b int) (int, error)"}},
+		{"c Cache[K, V], s struct{ a, b int }", []string{"c Cache[K, V]", "s struct{ a, b int }"}},
+		{"a int,", []string{"a int"}},
+	}
+	for _, test := range tests {
+		if got := splitTopLevel(test.s, ','); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitTopLevel(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		list string
+		want []Param
+	}{
+		{"", []Param{}},
+		{"int", []Param{{Type: "int"}}},
+		{"int, error", []Param{{Type: "int"}, {Type: "error"}}},
+		{"*User, error", []Param{{Type: "*User"}, {Type: "error"}}},
+		{"ctx context.Context, id string", []Param{{"ctx", "context.Context"}, {"id", "string"}}},
+		{"a, b int, s string", []Param{{"a", "int"}, {"b", "int"}, {"s", "string"}}},
+		{"n int, err error", []Param{{"n", "int"}, {"err", "error"}}},
+		{"format string, args ...interface{}", []Param{{"format", "string"}, {"args", "...interface{}"}}},
+		{"...string", []Param{{Type: "...string"}}},
+		{"K comparable, V any", []Param{{"K", "comparable"}, {"V", "any"}}},
+		{"K, V any", []Param{{"K", "any"}, {"V", "any"}}},
+		{"c *Cache[K, V], m map[K]V", []Param{{"c", "*Cache[K, V]"}, {"m", "map[K]V"}}},
+		{"f func(a, b int) (int, error)", []Param{{"f", "func(a, b int) (int, error)"}}},
+		{"func(string) bool", []Param{{Type: "func(string) bool"}}},
+		{"ch chan<- int, done <-chan struct{}", []Param{{"ch", "chan<- int"}, {"done", "<-chan struct{}"}}},
+		{"map[string]int, chan int", []Param{{Type: "map[string]int"}, {Type: "chan int"}}},
+	}
+	for _, test := range tests {
+		if got := ParseParams(test.list); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseParams(%q) = %+v, want %+v", test.list, got, test.want)
+		}
+	}
+}
+
+func TestJoinSignatures(t *testing.T) {
+	tests := []struct {
+		lines, want []string
+	}{
+		{
+			[]string{"func Get(id string) error {", "\treturn nil", "}"},
+			[]string{"func Get(id string) error {", "\treturn nil", "}"},
+		},
+		{
+			[]string{"func Get(", "\tctx context.Context,", "\tid string,", ") (*User, error) {", "}"},
+			[]string{"func Get(ctx context.Context, id string) (*User, error) {", "}"},
+		},
+		{
+			[]string{"func (s *Store) Find(ctx context.Context,", "\tids ...string) (", "\tusers []*User,", "\terr error,", ") {", "}"},
+			[]string{"func (s *Store) Find(ctx context.Context, ids ...string) (users []*User, err error) {", "}"},
+		},
+		{
+			[]string{"func Map[K comparable, V any](", "\tm map[K]V,", "\tf func(K, V) bool,", ") []K {", "}"},
+			[]string{"func Map[K comparable, V any](m map[K]V, f func(K, V) bool) []K {", "}"},
+		},
+		// Only declarations are joined, not calls in bodies.
+		{
+			[]string{"func Run() {", "\tdo(1,", "\t\t2)", "}"},
+			[]string{"func Run() {", "\tdo(1,", "\t\t2)", "}"},
+		},
+	}
+	for _, test := range tests {
+		if got := JoinSignatures(test.lines); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("JoinSignatures(%q) = %q, want %q", test.lines, got, test.want)
+		}
+	}
+}
+
+func TestParseFunc(t *testing.T) {
+	tests := []struct {
+		sig  string
+		want Func
+	}{
+		{
+			"func Run()",
+			Func{Name: "Run", Params: []Param{}},
+		},
+		{
+			"func (s *Store) Get(ctx context.Context, id string) (*User, error)",
+			Func{Name: "Get", Params: []Param{{"ctx", "context.Context"}, {"id", "string"}}, Results: []Param{{Type: "*User"}, {Type: "error"}}},
+		},
+		{
+			"func (c *Cache[K, V]) Put(key K, value V) error",
+			Func{Name: "Put", Params: []Param{{"key", "K"}, {"value", "V"}}, Results: []Param{{Type: "error"}}},
+		},
+		{
+			"func Printf(format string, args ...interface{}) (n int, err error)",
+			Func{Name: "Printf", Params: []Param{{"format", "string"}, {"args", "...interface{}"}}, Results: []Param{{"n", "int"}, {"err", "error"}}, Variadic: true},
+		},
+		{
+			"func Split(s string) (head, tail string)",
+			Func{Name: "Split", Params: []Param{{"s", "string"}}, Results: []Param{{"head", "string"}, {"tail", "string"}}},
+		},
+		{
+			"func Keys[K comparable, V any](m map[K]V) []K",
+			Func{Name: "Keys", TypeParams: []Param{{"K", "comparable"}, {"V", "any"}}, Params: []Param{{"m", "map[K]V"}}, Results: []Param{{Type: "[]K"}}},
+		},
+		{
+			"func Walk(root string, fn func(path string, err error) error) error",
+			Func{Name: "Walk", Params: []Param{{"root", "string"}, {"fn", "func(path string, err error) error"}}, Results: []Param{{Type: "error"}}},
+		},
+		{
+			"func Handler() func(w Writer, r *Request)",
+			Func{Name: "Handler", Params: []Param{}, Results: []Param{{Type: "func(w Writer, r *Request)"}}},
+		},
+		// Interface methods have no func keyword.
+		{
+			"Close() error",
+			Func{Name: "Close", Params: []Param{}, Results: []Param{{Type: "error"}}},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseFunc(test.sig)
+		if err != nil {
+			t.Errorf("ParseFunc(%q): %v", test.sig, err)
+			continue
+		}
+		got.Relationships = nil
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseFunc(%q) = %+v, want %+v", test.sig, got, test.want)
+		}
+	}
+
+	for _, sig := range []string{"func (s *Store Get()", "func Get(id string", "func (s *S)"} {
+		if _, err := ParseFunc(sig); err == nil {
+			t.Errorf("ParseFunc(%q) didn't fail", sig)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package app
+
+import (
+	"github.com/o/mod/store"
+	istore "github.com/o/mod/internal/store"
+)
+
+type App struct {
+	s *store.Store
+	c *istore.Cache
+}
+
+func (a *App) Run() {
+	a.s = &store.Store{}
+	a.c = &istore.Cache{}
+}
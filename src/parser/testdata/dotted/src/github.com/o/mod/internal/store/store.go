@@ -0,0 +1,5 @@
+package store
+
+type Cache struct {
+	Size int
+}
@@ -0,0 +1,11 @@
+package store
+
+type Store struct {
+	Name string
+}
+
+var Default = Store{}
+
+func Open(name string) *Store {
+	return &Store{Name: name}
+}
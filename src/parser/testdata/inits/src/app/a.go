@@ -0,0 +1,20 @@
+package app
+
+var (
+	first  int
+	second string
+)
+
+// Sets first.
+func init() {
+	first = 1
+}
+
+func Run(n int) int {
+	return n + first
+}
+
+// Sets second.
+func init() {
+	second = "b"
+}
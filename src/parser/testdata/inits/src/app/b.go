@@ -0,0 +1,7 @@
+package app
+
+var third bool
+
+func init() {
+	third = true
+}
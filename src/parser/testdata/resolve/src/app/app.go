@@ -0,0 +1,16 @@
+package app
+
+import (
+	"example.com/mod/store"
+	istore "example.com/mod/internal/store"
+)
+
+type App struct {
+	s *store.Store
+	c *istore.Cache
+}
+
+func (a *App) Run() {
+	a.s = &store.Store{}
+	a.c = &istore.Cache{}
+}
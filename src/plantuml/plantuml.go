@@ -0,0 +1,187 @@
+package plantuml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"../util"
+)
+
+// PlantUML's base64 alphabet for encoded diagram text.
+const ALPHABET = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_"
+
+// Longest encoded diagram sent in a GET request, longer ones are POSTed.
+const MAX_URL = 4096
+
+// Diagram text as used in PlantUML server URLs: deflated, then base64 encoded
+// with PlantUML's alphabet, 3 bytes to 4 characters.
+func Encode(text string) (string, error) {
+	buffer := &bytes.Buffer{}
+	w, err := flate.NewWriter(buffer, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write([]byte(text)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	data := buffer.Bytes()
+	var s strings.Builder
+	for i := 0; i < len(data); i += 3 {
+		var b [3]byte
+		copy(b[:], data[i:])
+		s.WriteByte(ALPHABET[b[0] >> 2])
+		s.WriteByte(ALPHABET[(b[0] & 0x3) << 4 | b[1] >> 4])
+		s.WriteByte(ALPHABET[(b[1] & 0xF) << 2 | b[2] >> 6])
+		s.WriteByte(ALPHABET[b[2] & 0x3F])
+	}
+	return s.String(), nil
+}
+
+// A PlantUML syntax error on a line of the diagram.
+type SyntaxError struct {
+	File			string
+	Line			int
+	Text			string
+	Message			string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s\n\t%s", e.File, e.Line, e.Message, e.Text)
+}
+
+func syntaxError(path, source, line, message string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, message)
+	}
+	e := &SyntaxError{File: path, Line: n, Message: strings.TrimSpace(message)}
+	// PlantUML counts lines from 1, eg. "@startuml".
+	if lines := strings.Split(source, "\n"); n >= 1 && n <= len(lines) {
+		e.Text = strings.TrimSpace(lines[n-1])
+	}
+	return e
+}
+
+// Run plantuml.jar on the diagram with -pipe. Syntax errors are reported on
+// stderr as "ERROR", the line number and the message.
+func jar(path, source string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), util.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "java", "-Djava.awt.headless=true", "-jar", util.PlantUML, "-t" + util.Render, "-pipe", "-charset", "UTF-8")
+	cmd.Stdin = strings.NewReader(source)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err := cmd.Run()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s: plantuml.jar timed out after %s", path, util.Timeout)
+	}
+	if split := strings.SplitN(stderr.String(), "\n", 3); len(split) == 3 && strings.TrimSpace(split[0]) == "ERROR" {
+		return nil, syntaxError(path, source, split[1], split[2])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: plantuml.jar: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Ask a PlantUML server, eg. picoweb or the Docker image. Syntax errors come
+// back as a 400 with the error and its line in headers.
+func server(path, source string) ([]byte, error) {
+	encoded, err := Encode(source)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: util.Timeout}
+	endpoint := strings.TrimSuffix(util.Server, "/") + "/" + util.Render + "/"
+	var resp *http.Response
+	if len(encoded) <= MAX_URL {
+		resp, err = client.Get(endpoint + encoded)
+	} else {
+		resp, err = client.Post(endpoint, "text/plain; charset=utf-8", strings.NewReader(source))
+	}
+	// Leave the encoded diagram out of errors.
+	if e, ok := err.(*url.Error); ok && e.Timeout() {
+		return nil, fmt.Errorf("%s: PlantUML server timed out after %s", path, util.Timeout)
+	} else if ok {
+		return nil, fmt.Errorf("%s: PlantUML server: %v", path, e.Err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if message := resp.Header.Get("X-PlantUML-Diagram-Error"); message != "" {
+		return nil, syntaxError(path, source, resp.Header.Get("X-PlantUML-Diagram-Error-Line"), message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: PlantUML server: %s", path, resp.Status)
+	}
+	return data, nil
+}
+
+// Render the .puml file at path to an image of the --render format next to
+// it, eg. "out/diagram.svg".
+func Render(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var image []byte
+	switch {
+	case util.Server != "":
+		image, err = server(path, string(data))
+	case util.PlantUML != "":
+		image, err = jar(path, string(data))
+	default:
+		err = errors.New("--render needs --plantuml or --server")
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(strings.TrimSuffix(path, filepath.Ext(path)) + "." + util.Render, image, 0644)
+}
+
+// Write the diagram to "<out>/<name>.puml" and return the path.
+func Write(name, source string) (string, error) {
+	path := filepath.Join(util.Out, filepath.FromSlash(name) + ".puml")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, ioutil.WriteFile(path, []byte(source + "\n"), 0644)
+}
+
+// Print the diagram, or with --render write it and render it.
+func Output(name, source string) error {
+	if util.Render == "" {
+		fmt.Println(source)
+		return nil
+	}
+
+	path, err := Write(name, source)
+	if err != nil {
+		return err
+	}
+	return Render(path)
+}
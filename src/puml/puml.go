@@ -2,28 +2,47 @@ package puml
 
 import (
 	"../parser"
+	"../audit"
 	"fmt"
 	"strings"
 	"regexp"
+	"sort"
+	"unicode"
+	"unicode/utf8"
 
+	"../plantuml"
+	"../style"
 	"../util"
 )
 
-var Relationships map[string]parser.Set = make(map[string]parser.Set)
-
 type Class struct {
 	Name			string
 	Type			string
+	TypeParams		[]parser.Param
 	PrivateVars		map[string]string
 	PublicVars		map[string]string
-	PrivateFuncs	parser.Set
-	PublicFuncs		parser.Set
+	PrivateFuncs	map[string]parser.Func
+	PublicFuncs		map[string]parser.Func
 	Relationships	parser.Set
+	// "namespace.Class" keys of the classes whose methods are called, with
+	// --calls.
+	Calls			parser.Set
+	Audit			map[string]audit.Global
+	Collapsed		bool
+	Consts			parser.Set
+	Pos				parser.Position
+	VarPositions	map[string]parser.Position
+	Doc				string
+	VarDocs			map[string]string
+	Embeds			[]string
 }
 
 type Namespace struct {
 	Name			string
+	Module			string
+	Doc				string
 	Classes			map[string]Class
+	Dependencies	map[string]int
 }
 
 type PlantUML struct {
@@ -34,15 +53,21 @@ func InitClass() Class {
 	var c Class
 	c.PrivateVars = make(map[string]string)
 	c.PublicVars = make(map[string]string)
-	c.PrivateFuncs = make(parser.Set)
-	c.PublicFuncs = make(parser.Set)
+	c.PrivateFuncs = make(map[string]parser.Func)
+	c.PublicFuncs = make(map[string]parser.Func)
 	c.Relationships = make(parser.Set)
+	c.Calls = make(parser.Set)
+	c.Audit = make(map[string]audit.Global)
+	c.Consts = make(parser.Set)
+	c.VarPositions = make(map[string]parser.Position)
+	c.VarDocs = make(map[string]string)
 	return c
 }
 
 func InitNamespace() Namespace {
 	var ns Namespace
 	ns.Classes = make(map[string]Class)
+	ns.Dependencies = make(map[string]int)
 	return ns
 }
 
@@ -53,35 +78,155 @@ func InitPlantUML() PlantUML {
 	return uml
 }
 
-func RelationshipsSet() parser.Set {
-	s := make(parser.Set)
-	for class, rs := range Relationships {
-		for r, _ := range rs {
-			found := false
-			var arrow string
-			if k, exists := Relationships[r]; exists {
-				if _, exists2 := k[class]; exists2 {
-					delete(k, class)
-					arrow = " --- "
-					found = true
-				}
+type Edge struct {
+	From			string
+	To				string
+	Kind			string
+}
+
+const (
+	ASSOCIATION = "association"
+	DEPENDENCY = "dependency"
+	CALLS = "calls"
+)
+
+// Relationships between "namespace.Class" keys, sorted. Classes using each
+// other share a single association. Calls found with --calls are edges of
+// their own.
+func (uml *PlantUML) Edges() []Edge {
+	rs := make(map[string]parser.Set)
+	edges := make([]Edge, 0)
+	for _, ns := range uml.Namespaces {
+		for _, c := range ns.Classes {
+			rs[ns.Name + "." + c.Name] = c.Relationships
+			for k, _ := range c.Calls {
+				edges = append(edges, Edge{From: ns.Name + "." + c.Name, To: k, Kind: CALLS})
 			}
-			if !found {
-				arrow = " --> "
-				
+		}
+	}
+
+	for class, r := range rs {
+		for k, _ := range r {
+			if back, exists := rs[k]; exists {
+				if _, both := back[class]; both {
+					if class <= k {
+						edges = append(edges, Edge{From: class, To: k, Kind: ASSOCIATION})
+					}
+					continue
+				}
 			}
-			s[class + arrow + r] = struct{}{}
+			edges = append(edges, Edge{From: class, To: k, Kind: DEPENDENCY})
 		}
 	}
 
-	return s
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Kind < edges[j].Kind
+	})
+	return edges
 }
 
-func parseToPUML(parse *parser.Parse) (*PlantUML, error) {
+// PlantUML relationship line of the edge.
+func (e Edge) PUMLString() string {
+	switch e.Kind {
+	case ASSOCIATION:
+		return ClassAlias(e.From) + " --- " + ClassAlias(e.To)
+	case CALLS:
+		return ClassAlias(e.From) + " ..> " + ClassAlias(e.To) + " : " + CALLS
+	default:
+		return ClassAlias(e.From) + " --> " + ClassAlias(e.To)
+	}
+}
+
+// Relationship lines of the diagram in the order of Edges. Edges to classes
+// that aren't drawn, eg. types of packages outside the source directory, are
+// left out.
+func (uml *PlantUML) Relationships() []string {
+	relationships := make([]string, 0)
+	for _, e := range uml.Edges() {
+		if uml.HasClass(e.From) && uml.HasClass(e.To) {
+			relationships = append(relationships, e.PUMLString())
+		}
+	}
+	return relationships
+}
+
+// Namespaces sorted by name.
+func (uml *PlantUML) SortedNamespaces() []Namespace {
+	namespaces := make([]Namespace, 0)
+	for _, ns := range uml.Namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces
+}
+
+// Classes sorted by name.
+func (ns *Namespace) SortedClasses() []Class {
+	classes := make([]Class, 0)
+	for _, c := range ns.Classes {
+		classes = append(classes, c)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Name < classes[j].Name
+	})
+	return classes
+}
+
+// Parsed namespaces ns depends on, sorted by name.
+func (uml *PlantUML) SortedDependencies(ns Namespace) []string {
+	deps := make([]string, 0)
+	for dep, _ := range ns.Dependencies {
+		if _, exists := uml.Namespaces[dep]; exists {
+			deps = append(deps, dep)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// Names of vars sorted, so members are written in the same order every run.
+func SortedVars(vars map[string]string) []string {
+	names := make([]string, 0)
+	for name, _ := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Funcs sorted by name.
+func SortedFuncs(funcs map[string]parser.Func) []parser.Func {
+	sorted := make([]parser.Func, 0)
+	for _, fn := range funcs {
+		sorted = append(sorted, fn)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+func ParseToPUML(parse *parser.Parse) (*PlantUML, error) {
 	uml := InitPlantUML()
+	audits := make(map[string]audit.Global)
+	if util.Audit {
+		audits = audit.AuditMap(audit.Audit(parse))
+	}
+
 	for _, pkg := range parse.Packages {
 		ns := InitNamespace()
 		ns.Name = pkg.Name
+		ns.Module = pkg.Module
+		ns.Doc = pkg.Doc
+		ns.dependencies(parse, pkg)
 
 		for _, f := range pkg.Files {
 			for _, t := range f.Types {
@@ -92,6 +237,42 @@ func parseToPUML(parse *parser.Parse) (*PlantUML, error) {
 					c.Type = t.Type
 				} 
 
+				if len(t.TypeParams) != 0 {
+					c.TypeParams = t.TypeParams
+				}
+
+				if c.Pos.Line == 0 || (t.Type != "" && c.Type == "") {
+					c.Pos = t.Pos
+				}
+
+				if c.Type == "" {
+					c.Type = t.Type
+				}
+
+				if c.Doc == "" {
+					c.Doc = t.Doc
+				}
+
+				for k, v := range t.VarPositions {
+					c.VarPositions[k] = v
+				}
+
+				for k, v := range t.VarDocs {
+					c.VarDocs[k] = v
+				}
+
+				if len(t.Embeds) != 0 {
+					c.Embeds = t.Embeds
+				}
+
+				for k, _ := range t.Consts {
+					c.Consts[k] = struct{}{}
+				}
+
+				if util.Visibility == "exported" {
+					t = exportedType(t)
+				}
+
 				for k, v := range t.PrivateVars {
 					c.PrivateVars[k] = v
 				}
@@ -100,19 +281,43 @@ func parseToPUML(parse *parser.Parse) (*PlantUML, error) {
 					c.PublicVars[k] = v
 				}
 
-				for k, _ := range t.PrivateFuncs {
-					c.PrivateFuncs[k] = struct{}{}
+				if t.Type == "global" {
+					for k, v := range audits {
+						if strings.HasPrefix(k, pkg.Name + ".") {
+							c.Audit[v.Name] = v
+						}
+					}
 				}
 
-				for k, _ := range t.PublicFuncs {
-					c.PublicFuncs[k] = struct{}{}
+				for k, v := range t.PrivateFuncs {
+					c.PrivateFuncs[k] = v
+				}
+
+				for k, v := range t.PublicFuncs {
+					c.PublicFuncs[k] = v
+				}
+
+				for _, funcs := range []map[string]parser.Func{t.PublicFuncs, t.PrivateFuncs} {
+					for _, fn := range funcs {
+						for k, _ := range fn.Calls {
+							to := k[:strings.LastIndex(k, ".")]
+							if to == pkg.Name + "." + t.Name {
+								continue
+							}
+							if !util.Global && strings.HasPrefix(to, pkg.Name + ".") && (strings.Contains(to, "Global") || strings.Contains(c.Name, "Global")) {
+								continue
+							}
+							c.Calls[to] = struct{}{}
+						}
+					}
 				}
 
 				for k, _ := range t.Relationships {
-					split := strings.Split(k, ".")
-					if len(split) != 2 {
+					i := strings.LastIndex(k, ".")
+					if i < 0 {
 						return nil, fmt.Errorf("Failed to get package for %s", k)
 					}
+					split := []string{k[:i], k[i+1:]}
 
 					if !util.Global {
 						if pkg.Name == split[0] && (strings.Contains(k, "Global") || strings.Contains(c.Name, "Global")) {
@@ -128,7 +333,7 @@ func parseToPUML(parse *parser.Parse) (*PlantUML, error) {
 					} else {						
 						if _, exists := parse.Packages[split[0]]; exists {
 							if util.Global && split[0] != pkg.Name {
-								c.Relationships[split[0] + "." + strings.Title(split[0]) + "Global"] = struct{}{}
+								c.Relationships[split[0] + "." + parser.GlobalName(split[0])] = struct{}{}
 							}
 						}
 					}
@@ -138,162 +343,887 @@ func parseToPUML(parse *parser.Parse) (*PlantUML, error) {
 		}
 		uml.Namespaces[ns.Name] = ns
 	}
+
+	if err := uml.Filter(); err != nil {
+		return nil, err
+	}
 	return &uml, nil
 }
 
+// Apply --visibility and --focus to the model.
+func (uml *PlantUML) Filter() error {
+	if util.Visibility == "exported" {
+		uml.pruneUnexported()
+	}
+
+	if util.Focus != "" {
+		return uml.focus(util.Focus, util.Depth, util.Direction)
+	}
+	return nil
+}
+
+// Packages imported by pkg, weighted by how many type level references each
+// import stands for.
+func (ns *Namespace) dependencies(parse *parser.Parse, pkg parser.Package) {
+	for _, f := range pkg.Files {
+		for _, ip := range f.Imports {
+			_, exists := parse.Packages[ip]
+			if _, counted := ns.Dependencies[ip]; exists && !counted && ip != pkg.Name {
+				ns.Dependencies[ip] = 0
+			}
+		}
+
+		// Only references to types count, not to funcs or vars.
+		for _, t := range f.Types {
+			for k, _ := range t.Relationships {
+				i := strings.LastIndex(k, ".")
+				if _, isType := parse.TypeMap[k]; i < 0 || !isType {
+					continue
+				}
+				if _, exists := parse.Packages[k[:i]]; exists && k[:i] != pkg.Name {
+					ns.Dependencies[k[:i]]++
+				}
+			}
+		}
+	}
+}
+
+// Public API view of t. Relationships only come from exported functions.
+func exportedType(t parser.Type) parser.Type {
+	e := parser.InitType()
+	e.Name = t.Name
+	e.Type = t.Type
+	e.PublicVars = t.PublicVars
+	e.PublicFuncs = t.PublicFuncs
+	e.TypeParams = t.TypeParams
+	e.Consts = t.Consts
+	e.Pos = t.Pos
+	e.VarPositions = t.VarPositions
+	e.Doc = t.Doc
+	e.VarDocs = t.VarDocs
+	e.Embeds = t.Embeds
+	for _, fn := range t.PublicFuncs {
+		for k, _ := range fn.Relationships {
+			e.Relationships[k] = struct{}{}
+		}
+	}
+	return e
+}
+
+func exported(name string) bool {
+	return strings.ToUpper(string(name[0])) == string(name[0])
+}
+
+// Drop unexported members, classes with no exported surface and the edges
+// pointing to them.
+func (uml *PlantUML) pruneUnexported() {
+	for _, ns := range uml.Namespaces {
+		for name, c := range ns.Classes {
+			c.PrivateVars = make(map[string]string)
+			c.PrivateFuncs = make(map[string]parser.Func)
+			ns.Classes[name] = c
+
+			if c.Type == "global" && len(c.PublicVars) == 0 && len(c.PublicFuncs) == 0 {
+				delete(ns.Classes, name)
+			} else if c.Type != "global" && !exported(name) {
+				delete(ns.Classes, name)
+			}
+		}
+	}
+
+	for _, ns := range uml.Namespaces {
+		for _, c := range ns.Classes {
+			for k, _ := range c.Relationships {
+				if !uml.HasClass(k) {
+					delete(c.Relationships, k)
+				}
+			}
+			for k, _ := range c.Calls {
+				if !uml.HasClass(k) {
+					delete(c.Calls, k)
+				}
+			}
+		}
+	}
+}
+
+// Classes reachable from each "namespace.Class" key following relationships
+// "out", "in" or "both" ways.
+func (uml *PlantUML) Neighbours(direction string) map[string][]string {
+	neighbours := make(map[string][]string)
+	for _, ns := range uml.Namespaces {
+		for _, c := range ns.Classes {
+			class := ns.Name + "." + c.Name
+			for _, rs := range []parser.Set{c.Relationships, c.Calls} {
+				for k, _ := range rs {
+					if direction != "in" {
+						neighbours[class] = append(neighbours[class], k)
+					}
+					if direction != "out" {
+						neighbours[k] = append(neighbours[k], class)
+					}
+				}
+			}
+		}
+	}
+	return neighbours
+}
+
+// Keep the classes within depth hops of class. Classes on the boundary are
+// collapsed to their name.
+func (uml *PlantUML) focus(class string, depth int, direction string) error {
+	if !uml.HasClass(class) {
+		return fmt.Errorf("Couldn't find class to focus on: %s", class)
+	}
+
+	neighbours := uml.Neighbours(direction)
+	distance := map[string]int{class: 0}
+	queue := []string{class}
+	for len(queue) != 0 {
+		k := queue[0]
+		queue = queue[1:]
+		if distance[k] >= depth {
+			continue
+		}
+		for _, n := range neighbours[k] {
+			if _, seen := distance[n]; !seen && uml.HasClass(n) {
+				distance[n] = distance[k] + 1
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	for _, ns := range uml.Namespaces {
+		for name, c := range ns.Classes {
+			d, reached := distance[ns.Name + "." + name]
+			if !reached {
+				delete(ns.Classes, name)
+				continue
+			}
+			if d == depth && d != 0 {
+				c.Collapsed = true
+				ns.Classes[name] = c
+			}
+		}
+		if len(ns.Classes) == 0 {
+			delete(uml.Namespaces, ns.Name)
+		}
+	}
+
+	for _, ns := range uml.Namespaces {
+		for _, c := range ns.Classes {
+			for k, _ := range c.Relationships {
+				if !uml.HasClass(k) {
+					delete(c.Relationships, k)
+				}
+			}
+			for k, _ := range c.Calls {
+				if !uml.HasClass(k) {
+					delete(c.Calls, k)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Whether the "namespace.Class" key is in the diagram.
+func (uml *PlantUML) HasClass(key string) bool {
+	i := strings.LastIndex(key, ".")
+	if i < 0 {
+		return false
+	}
+	ns, exists := uml.Namespaces[key[:i]]
+	if !exists {
+		return false
+	}
+	_, exists = ns.Classes[key[i+1:]]
+	return exists
+}
+
 const (
-	GLOBAL = "<< (G,Green) >>"
-	STRUCT = "<< (S,Aquamarine) >>"
-	TYPE = "<< (T, #FF7700) >>"
-	COLOR_REPLACE = "<font color=%s>%s</font>"
-	FUNC = "\"%s \" as %s.%s"
+	INIT = "<<init>>"
+	MAIN = "<<main>>"
+	GOROUTINE = "<<goroutine>>"
+	MUTABLE = "<<mutable>>"
+	SHARED = "<<shared>>"
+	MUTABLE_COLOR = "#E67E22"
+	SHARED_COLOR = "#C0392B"
+	// Name of the index diagram of --split=package.
+	INDEX = "index"
+	FUNC = "%s as %s"
 )
 
-func colorText(text, replace, color string) string {
-	colored := fmt.Sprintf(COLOR_REPLACE, color, replace)
-	n := strings.Count(text, replace)
-	return strings.Replace(text, replace, colored, n)
+func ParamsString(params []parser.Param) string {
+	split := make([]string, 0)
+	for _, p := range params {
+		if p.Name == "" || util.Signatures == "short" {
+			split = append(split, p.Type)
+		} else {
+			split = append(split, p.Name + " " + p.Type)
+		}
+	}
+	return strings.Join(split, ", ")
 }
 
-func blueType(text string) string {
-	t := text
-	if strings.Contains(t, "map") {
-		t = colorText(t, "map", "blue")
+// PlantUML style signature, eg. "Get(ctx Context, id string) : (*User, error)".
+// Short signatures leave out parameter and result names.
+func FuncString(fn parser.Func) string {
+	s := fn.Name
+	if len(fn.TypeParams) != 0 {
+		s += "[" + ParamsString(fn.TypeParams) + "]"
 	}
+	s += "(" + ParamsString(fn.Params) + ")"
 
-	if strings.Contains(t, "struct") {
-		t = colorText(t, "struct", "blue")
+	switch {
+	case len(fn.Results) == 0:
+	case len(fn.Results) == 1 && (fn.Results[0].Name == "" || util.Signatures == "short"):
+		s += " : " + fn.Results[0].Type
+	default:
+		s += " : (" + ParamsString(fn.Results) + ")"
 	}
+	return s
+}
 
-	if strings.Contains(t, "chan") {
-		t = colorText(t, "chan", "blue")
+// Vars found by the audit are coloured, shared state over mutable state.
+func (c *Class) varString(name, typ string) string {
+	s := name + " " + typ
+	g, exists := c.Audit[name]
+	switch {
+	case !exists || !g.Mutable:
+		return s
+	case g.Shared:
+		return fmt.Sprintf("<color:%s>%s</color> %s", SHARED_COLOR, s, SHARED)
+	default:
+		return fmt.Sprintf("<color:%s>%s</color> %s", MUTABLE_COLOR, s, MUTABLE)
 	}
+}
 
-	if strings.Contains(t, "func") {
-		t = colorText(t, "func", "blue")
+// Package init() and main() get their own stereotypes, as do functions that are
+// started as goroutines anywhere in the package.
+func (c *Class) funcStereotypes(fn parser.Func) string {
+	var s string
+	if c.Type == "global" {
+		switch fn.Name {
+		case "init":
+			s += " " + INIT
+		case "main":
+			s += " " + MAIN
+		}
 	}
-	return t
+
+	if fn.Goroutine {
+		s += " " + GOROUTINE
+	}
+	return s
 }
 
 func (c * Class) PUMLString(namespace string) string {
 	var puml, symbol string
 
 	switch c.Type {
-	case "global":
-		symbol = GLOBAL
-	case "struct":
-		symbol = STRUCT
+	case "global", "struct":
+		symbol = style.Spot(c.Type)
 	default:
-		symbol = TYPE
+		symbol = style.Spot("type")
 	}
 
-	puml += fmt.Sprintf("class %s.%s %s {\n", namespace, c.Name, symbol)
+	var generics string
+	if len(c.TypeParams) != 0 {
+		generics = "<" + ParamsString(c.TypeParams) + ">"
+	}
+
+	puml += fmt.Sprintf("class %s as %s %s {\n", quote(c.Name + generics), ClassAlias(namespace + "." + c.Name), symbol)
+	if c.Collapsed {
+		return puml + "}\n"
+	}
 
-	for k, v := range c.PrivateVars {
-		puml += fmt.Sprintf("\t- %s %s\n", k, v)
+	for _, k := range SortedVars(c.PrivateVars) {
+		puml += fmt.Sprintf("\t- %s\n", c.varString(k, c.PrivateVars[k]))
 	}
 
 	if len(c.PrivateVars) != 0 {
 		puml += "\n"
 	}
 	
-	for k, v := range c.PublicVars {
-		puml += fmt.Sprintf("\t+ %s %s\n", k, v)
+	for _, k := range SortedVars(c.PublicVars) {
+		puml += fmt.Sprintf("\t+ %s\n", c.varString(k, c.PublicVars[k]))
 	}
 
 	if len(c.PrivateFuncs) != 0 {
 		puml += "\n"
 	}
 
-	for k, _ := range c.PrivateFuncs {
-		puml += fmt.Sprintf("\t- %s\n", k)
+	for _, v := range SortedFuncs(c.PrivateFuncs) {
+		puml += fmt.Sprintf("\t- %s%s\n", FuncString(v), c.funcStereotypes(v))
 	}
 
 	if len(c.PublicFuncs) != 0 {
 		puml += "\n"
 	}
 
-	for k, _ := range c.PublicFuncs {
-		puml += fmt.Sprintf("\t+ %s\n", k)
+	for _, v := range SortedFuncs(c.PublicFuncs) {
+		puml += fmt.Sprintf("\t+ %s%s\n", FuncString(v), c.funcStereotypes(v))
 	}
 
 	puml += "}\n"
 
-	class := namespace + "." + c.Name
-	ps, exists := Relationships[class]
-	if !exists {
-		ps = make(parser.Set)
-	}
-
-	for k, _ := range c.Relationships {
-		ps[k] = struct{}{}
-	}
-
-	Relationships[class] = ps
-
 	if !strings.HasPrefix(c.Type, "func") {
 		return puml
 	}
 
-	blueT := blueType(c.Type)
-	re := regexp.MustCompile("[^a-zA-Z\\d\\s:]")
-	clean := re.ReplaceAllString(c.Type, "")
-	n := strings.Count(clean, " ")
-	clean = strings.Replace(clean, " ", "", n)
+	blueT := style.Keywords(c.Type)
 
-	fullText := fmt.Sprintf(FUNC, blueT, namespace, clean)
+	// One node per signature, shared by func types of the same namespace.
+	node := alias(namespace + "." + c.Type)
+	fullText := fmt.Sprintf(FUNC, quote(blueT + " "), node)
 	puml += fmt.Sprintf("class %s {\n}\n", fullText)
-	puml += fmt.Sprintf("\"%s.%s\" #.. \"%s\"\n", namespace, clean, c.Name)
+	puml += fmt.Sprintf("%s #.. %s\n", node, ClassAlias(namespace + "." + c.Name))
 
 	return puml
 }
 
 func (ns *Namespace) PUMLString() string {
+	return ns.groupedPUMLString(nil)
+}
+
+// Classes of each group are drawn in a together block.
+func (ns *Namespace) groupedPUMLString(groups [][]string) string {
 	var puml string
-	puml += fmt.Sprintf("namespace %s {\n\t", ns.Name)
+	grouped := make(parser.Set)
+	for _, group := range groups {
+		puml += "together {\n\t"
+		for _, name := range group {
+			c := ns.Classes[name]
+			puml += strings.Replace(c.PUMLString(ns.Name), "\n", "\n\t", -1)
+			grouped[name] = struct{}{}
+		}
+		puml = strings.TrimSuffix(puml, "\t") + "}\n"
+	}
 
-	for _, c := range ns.Classes {
-		cStr := c.PUMLString(ns.Name)
-		n := strings.Count(cStr, "\n")
-		cStr = strings.Replace(cStr, "\n", "\n\t", n)
-		puml += cStr
+	for _, c := range ns.SortedClasses() {
+		if _, exists := grouped[c.Name]; exists {
+			continue
+		}
+		puml += c.PUMLString(ns.Name)
+	}
+	return puml
+}
+
+// Classes of ns connected by relationships within ns, for --together.
+func (uml *PlantUML) groups(ns Namespace) [][]string {
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(name string) string {
+		if p, exists := parent[name]; exists && p != name {
+			parent[name] = find(p)
+			return parent[name]
+		}
+		return name
+	}
+
+	for _, e := range uml.Edges() {
+		from, to := e.From[strings.LastIndex(e.From, ".")+1:], e.To[strings.LastIndex(e.To, ".")+1:]
+		if e.From != ns.Name + "." + from || e.To != ns.Name + "." + to {
+			continue
+		}
+		_, fromExists := ns.Classes[from]
+		_, toExists := ns.Classes[to]
+		if fromExists && toExists {
+			parent[find(from)] = find(to)
+		}
 	}
 
-	puml += "\n}\n"
+	members := make(map[string][]string)
+	for _, c := range ns.SortedClasses() {
+		root := find(c.Name)
+		members[root] = append(members[root], c.Name)
+	}
+	groups := make([][]string, 0)
+	for _, group := range members {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+	return groups
+}
+
+func (uml *PlantUML) namespacePUMLString(ns Namespace) string {
+	if !util.Together {
+		return ns.PUMLString()
+	}
+	return ns.groupedPUMLString(uml.groups(ns))
+}
+
+// Number of leading path segments all namespaces share, eg. 2 for
+// "github.com/org/a" and "github.com/org/b/c", dropped with --collapse-prefix.
+// Each namespace keeps at least its last segment.
+func (uml *PlantUML) commonPrefix() int {
+	if !util.CollapsePrefix {
+		return 0
+	}
+	var prefix []string
+	n := -1
+	for _, ns := range uml.SortedNamespaces() {
+		segments := strings.Split(ns.Name, "/")
+		if n < 0 {
+			prefix, n = segments, len(segments) - 1
+			continue
+		}
+		if len(segments) - 1 < n {
+			n = len(segments) - 1
+		}
+		for i := 0; i < n; i++ {
+			if segments[i] != prefix[i] {
+				n = i
+				break
+			}
+		}
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Name of the namespace as drawn, without the common prefix with
+// --collapse-prefix.
+func (uml *PlantUML) displayName(name string) string {
+	segments := strings.Split(name, "/")
+	return strings.Join(segments[uml.commonPrefix():], "/")
+}
+
+// Package of the import path hierarchy, holding the classes of the namespace of
+// the same path if there is one.
+type packageNode struct {
+	Path			string
+	Segment			string
+	Namespace		*Namespace
+	Children		[]*packageNode
+}
+
+// Nested package blocks for the namespaces, in order. Packages of path segments
+// without a namespace of their own, eg. "internal", only hold others.
+func (uml *PlantUML) packageTree(namespaces []Namespace) []*packageNode {
+	nodes := make(map[string]*packageNode)
+	roots := make([]*packageNode, 0)
+	prefix := uml.commonPrefix()
+	for i, _ := range namespaces {
+		ns := &namespaces[i]
+		segments := strings.Split(ns.Name, "/")
+		var parent *packageNode
+		for j := prefix; j < len(segments); j++ {
+			path := strings.Join(segments[:j+1], "/")
+			node, exists := nodes[path]
+			if !exists {
+				node = &packageNode{Path: path, Segment: segments[j]}
+				if parent == nil {
+					roots = append(roots, node)
+				} else {
+					parent.Children = append(parent.Children, node)
+				}
+				nodes[path] = node
+			}
+			parent = node
+		}
+		parent.Namespace = ns
+	}
+	return roots
+}
+
+func (uml *PlantUML) nodePUMLString(node *packageNode) string {
+	puml := fmt.Sprintf("package %s as %s {\n", quote(node.Segment), PackageAlias(node.Path))
+	var body string
+	if node.Namespace != nil {
+		body += uml.namespacePUMLString(*node.Namespace)
+	}
+	for _, child := range node.Children {
+		body += uml.nodePUMLString(child)
+	}
+	for _, line := range strings.SplitAfter(body, "\n") {
+		if strings.TrimSpace(line) != "" {
+			puml += "\t" + line
+		} else if line != "" {
+			puml += "\n"
+		}
+	}
+	return puml + "}\n"
+}
+
+// Namespaces as packages nested after their import paths.
+func (uml *PlantUML) classesPUMLString(namespaces []Namespace) string {
+	var puml string
+	for i, node := range uml.packageTree(namespaces) {
+		if i != 0 {
+			puml += "\n"
+		}
+		puml += uml.nodePUMLString(node)
+	}
 	return puml
 }
 
-func GeneratePUML(parse *parser.Parse) error {
-	uml, err := parseToPUML(parse)
-	if err != nil {
-		return err
+// Namespaces by dependency depth, packages depending on others first, so with
+// --layering the lowest level packages end up at the bottom. Cycles are cut
+// where they are found.
+func (uml *PlantUML) Layers() [][]Namespace {
+	depth := make(map[string]int)
+	var visit func(string, parser.Set) int
+	visit = func(name string, path parser.Set) int {
+		if d, exists := depth[name]; exists {
+			return d
+		}
+		path[name] = struct{}{}
+		d := 0
+		for dep, _ := range uml.Namespaces[name].Dependencies {
+			_, exists := uml.Namespaces[dep]
+			_, cycle := path[dep]
+			if !exists || cycle {
+				continue
+			}
+			if n := visit(dep, path) + 1; n > d {
+				d = n
+			}
+		}
+		delete(path, name)
+		depth[name] = d
+		return d
+	}
+
+	max := 0
+	for _, ns := range uml.SortedNamespaces() {
+		if d := visit(ns.Name, make(parser.Set)); d > max {
+			max = d
+		}
+	}
+	layers := make([][]Namespace, max + 1)
+	for _, ns := range uml.SortedNamespaces() {
+		i := max - depth[ns.Name]
+		layers[i] = append(layers[i], ns)
+	}
+	return layers
+}
+
+func (uml *PlantUML) orderedNamespaces() []Namespace {
+	if !util.Layering {
+		return uml.SortedNamespaces()
+	}
+	namespaces := make([]Namespace, 0)
+	for _, layer := range uml.Layers() {
+		namespaces = append(namespaces, layer...)
 	}
+	return namespaces
+}
 
+// Hidden edges from the first namespace of each layer to the namespaces of the
+// next, between the nodes given by node.
+func (uml *PlantUML) layeringPUMLString(node func(Namespace) string) string {
+	if !util.Layering {
+		return ""
+	}
 	var puml string
-	puml += "@startuml\n"
-	i := 0
-	for _, ns := range uml.Namespaces {
-		puml += ns.PUMLString()
-		if i < len(uml.Namespaces) - 1 {
+	layers := uml.Layers()
+	for i := 0; i + 1 < len(layers); i++ {
+		from := node(layers[i][0])
+		for _, ns := range layers[i+1] {
+			if to := node(ns); from != "" && to != "" {
+				puml += fmt.Sprintf("%s -[hidden]down- %s\n", from, to)
+			}
+		}
+	}
+	return puml
+}
+
+// Start of a diagram with the style and layout of the flags.
+func header() string {
+	// Packages are nested by their blocks, not by dots in names.
+	puml := "@startuml\n" + style.Header() + "set separator none\n"
+	if util.Rankdir == "LR" {
+		puml += "left to right direction\n"
+	}
+	if util.HideEmpty {
+		puml += "hide empty members\n"
+	}
+	return puml
+}
+
+// PlantUML id of name. ASCII letters and digits are kept, "_" is doubled and
+// any other rune becomes "_<hex code point>_", so that no two names share an
+// id, eg. "a/b.C" is "a_2f_b_2e_C" and "a_b.C" is "a__b_2e_C".
+func alias(name string) string {
+	var id strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '_':
+			id.WriteString("__")
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			id.WriteRune(r)
+		default:
+			fmt.Fprintf(&id, "_%x_", r)
+		}
+	}
+	return id.String()
+}
+
+// PlantUML id of a "namespace.Class" key.
+func ClassAlias(key string) string {
+	return alias(key)
+}
+
+// PlantUML id of a namespace or a directory above namespaces. The trailing
+// slash keeps it apart from the ids of classes, whose names have no slashes.
+func PackageAlias(path string) string {
+	return alias(path + "/")
+}
+
+// Quoted PlantUML name. Quotes can't be escaped within one, so they are drawn
+// as an HTML entity.
+func quote(name string) string {
+	return "\"" + strings.Replace(name, "\"", "&#34;", -1) + "\""
+}
+
+const (
+	DECLARATION_REGEX = "^(class|package|component) \"([^\"]*)\" as (\\S+)(\\s.*)?$"
+	RELATIONSHIP_REGEX = "^(\\S+) (\\S+) (\\S+)( : .*)?$"
+	ID_REGEX = "^[A-Za-z0-9_]+$"
+)
+
+// Check the diagram written to "<name>.puml" only holds what PlantUML parses:
+// blocks are closed, ids are declared once per name and relationships are
+// between declared ids. Errors are reported like PlantUML's own.
+func Validate(name, source string) error {
+	declaration := regexp.MustCompile(DECLARATION_REGEX)
+	relationship := regexp.MustCompile(RELATIONSHIP_REGEX)
+	id := regexp.MustCompile(ID_REGEX)
+
+	lines := strings.Split(source, "\n")
+	fail := func(i int, message string) error {
+		return &plantuml.SyntaxError{File: name + ".puml", Line: i + 1, Text: strings.TrimSpace(lines[i]), Message: message}
+	}
+
+	declared := make(map[string]string)
+	edges := make(map[int][]string)
+	depth, members := 0, false
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		matches := declaration.FindStringSubmatch(line)
+		switch {
+		case i == 0:
+			if line != "@startuml" {
+				return fail(i, "Diagram doesn't start with @startuml")
+			}
+		case line == "@enduml":
+			if depth != 0 {
+				return fail(i, "Block isn't closed")
+			}
+			if i != len(lines) - 1 {
+				return fail(i, "Lines after @enduml")
+			}
+		case members:
+			if line == "}" {
+				members = false
+				depth--
+			}
+		case line == "" || line == "left to right direction" || line == "hide empty members":
+		case strings.HasPrefix(line, "!theme ") || strings.HasPrefix(line, "skinparam ") || strings.HasPrefix(line, "set "):
+		case line == "together {":
+			depth++
+		case line == "}":
+			if depth--; depth < 0 {
+				return fail(i, "Closing a block that isn't open")
+			}
+		case matches != nil:
+			if !id.MatchString(matches[3]) {
+				return fail(i, "Invalid id " + matches[3])
+			}
+			if d, exists := declared[matches[3]]; exists && d != matches[1] + " " + matches[2] {
+				return fail(i, "Id " + matches[3] + " is already declared as " + d)
+			}
+			declared[matches[3]] = matches[1] + " " + matches[2]
+			if strings.HasSuffix(line, "{") {
+				depth++
+				members = matches[1] == "class"
+			}
+		case relationship.MatchString(line):
+			matches = relationship.FindStringSubmatch(line)
+			edges[i] = []string{matches[1], matches[3]}
+		default:
+			return fail(i, "Unexpected line")
+		}
+	}
+	if last := strings.TrimSpace(lines[len(lines) - 1]); last != "@enduml" {
+		return fail(len(lines) - 1, "Diagram doesn't end with @enduml")
+	}
+
+	for i := 0; i < len(lines); i++ {
+		for _, to := range edges[i] {
+			if _, exists := declared[to]; !exists {
+				return fail(i, "Undeclared id " + to)
+			}
+		}
+	}
+	return nil
+}
+
+// Architecture view with one component per namespace. Edges are labelled with
+// the number of type level references behind them.
+func (uml *PlantUML) PackagePUMLString() string {
+	return uml.packagePUMLString("")
+}
+
+// Package view linking each component to its diagram, "<namespace>.<ext>".
+func (uml *PlantUML) IndexPUMLString(ext string) string {
+	return uml.packagePUMLString(ext)
+}
+
+func (uml *PlantUML) packagePUMLString(ext string) string {
+	var puml string
+	for _, ns := range uml.orderedNamespaces() {
+		puml += fmt.Sprintf("component %s as %s", quote(uml.displayName(ns.Name)), PackageAlias(ns.Name))
+		if ext != "" {
+			puml += fmt.Sprintf(" [[%s.%s]]", ns.Name, ext)
+		}
+		puml += "\n"
+	}
+
+	for _, ns := range uml.SortedNamespaces() {
+		for _, dep := range uml.SortedDependencies(ns) {
+			puml += fmt.Sprintf("%s --> %s", PackageAlias(ns.Name), PackageAlias(dep))
+			if n := ns.Dependencies[dep]; n != 0 {
+				puml += fmt.Sprintf(" : %d", n)
+			}
 			puml += "\n"
 		}
-		i++
 	}
+	return puml + uml.layeringPUMLString(func(ns Namespace) string {
+		return PackageAlias(ns.Name)
+	})
+}
 
-	s := RelationshipsSet()
-	for r, _ := range s {
-		puml += r + "\n"
+// Diagram of one namespace. Classes of other namespaces it has relationships
+// with are drawn collapsed, as on the boundary of a focused diagram.
+func (uml *PlantUML) SplitPUMLString(name string) string {
+	split := InitPlantUML()
+	split.Namespaces[name] = uml.Namespaces[name]
+	edges := make([]Edge, 0)
+	for _, e := range uml.Edges() {
+		from := e.From[:strings.LastIndex(e.From, ".")]
+		to := e.To[:strings.LastIndex(e.To, ".")]
+		if (from != name && to != name) || !uml.HasClass(e.From) || !uml.HasClass(e.To) {
+			continue
+		}
+		edges = append(edges, e)
+		for _, key := range []string{e.From, e.To} {
+			i := strings.LastIndex(key, ".")
+			if key[:i] == name {
+				continue
+			}
+			ns, exists := split.Namespaces[key[:i]]
+			if !exists {
+				ns = InitNamespace()
+				ns.Name = key[:i]
+				split.Namespaces[ns.Name] = ns
+			}
+			c := uml.Namespaces[key[:i]].Classes[key[i+1:]]
+			c.Collapsed = true
+			ns.Classes[c.Name] = c
+		}
 	}
-	puml += "@enduml"
 
-	if !util.Debug {
-		fmt.Println(puml)
+	puml := header() + split.classesPUMLString(split.SortedNamespaces())
+	for _, e := range edges {
+		puml += e.PUMLString() + "\n"
+	}
+	return puml + "@enduml"
+}
+
+// Write one diagram per namespace and an index diagram linking to them under
+// util.Out, rendering them with --render.
+func (uml *PlantUML) RenderSplit() error {
+	diagrams := make(map[string]string)
+	for _, ns := range uml.SortedNamespaces() {
+		diagrams[ns.Name] = uml.SplitPUMLString(ns.Name)
+	}
+
+	ext := "puml"
+	if util.Render != "" {
+		ext = util.Render
+	}
+	if _, exists := diagrams[INDEX]; exists {
+		return fmt.Errorf("Package %s clashes with the index diagram", INDEX)
+	}
+	diagrams[INDEX] = header() + uml.IndexPUMLString(ext) + "@enduml"
+	for name, diagram := range diagrams {
+		if err := Validate(name, diagram); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0)
+	for name, _ := range diagrams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path, err := plantuml.Write(name, diagrams[name])
+		if err != nil {
+			return err
+		}
+		if util.Render == "" {
+			continue
+		}
+		if err := plantuml.Render(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GeneratePUML(parse *parser.Parse) error {
+	uml, err := ParseToPUML(parse)
+	if err != nil {
+		return err
+	}
+	return RenderPUML(uml)
+}
+
+func RenderPUML(uml *PlantUML) error {
+	if util.Split == "package" && !util.Debug {
+		return uml.RenderSplit()
+	}
+
+	puml := header()
+	relationships := make([]string, 0)
+	if util.Level == "package" {
+		puml += uml.PackagePUMLString()
 	} else {
-		data, err := util.Dump(s)
+		puml += uml.classesPUMLString(uml.orderedNamespaces())
+
+		relationships = uml.Relationships()
+		for _, r := range relationships {
+			puml += r + "\n"
+		}
+		puml += uml.layeringPUMLString(func(ns Namespace) string {
+			if classes := ns.SortedClasses(); len(classes) != 0 {
+				return ClassAlias(ns.Name + "." + classes[0].Name)
+			}
+			return ""
+		})
+	}
+	puml += "@enduml"
+
+	if util.Debug {
+		data, err := util.Dump(relationships)
 		if err != nil {
 			return err
 		}
 		fmt.Println("Relationships:", string(data))
+		return nil
 	}
-	return nil
+	if err := Validate("diagram", puml); err != nil {
+		return err
+	}
+	return plantuml.Output("diagram", puml)
 }
\ No newline at end of file
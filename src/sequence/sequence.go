@@ -0,0 +1,328 @@
+package sequence
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"strings"
+
+	"../callgraph"
+	"../parser"
+	"../plantuml"
+	"../puml"
+	"../style"
+	"../util"
+)
+
+const (
+	MESSAGE = "%s %s %s : %s\n"
+	RETURN = "%s --> %s\n"
+	PARTICIPANT = "participant \"%s\" as %s\n"
+)
+
+// A branch of an alt, loop or par fragment.
+type branch struct {
+	Label			string
+	Body			string
+}
+
+type writer struct {
+	g				*callgraph.Graph
+	depth			int
+	participants	[]callgraph.Func
+	seen			map[string]bool
+	// Functions being expanded, so recursion stops.
+	stack			map[string]bool
+	// Deferred calls of each function being expanded, run at its end.
+	defers			[][]string
+}
+
+// Single line source text of node, for labels.
+func (w *writer) text(node ast.Node) string {
+	var buffer bytes.Buffer
+	if err := printer.Fprint(&buffer, w.g.Fset, node); err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(buffer.String()), " ")
+}
+
+func indent(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "\t" + strings.Replace(strings.TrimSuffix(s, "\n"), "\n", "\n\t", -1) + "\n"
+}
+
+// Fragment of branches, left out when none of them has a message.
+func fragment(kind string, branches []branch) string {
+	var body string
+	for _, b := range branches {
+		body += b.Body
+	}
+	if body == "" {
+		return ""
+	}
+
+	var s string
+	for i, b := range branches {
+		if i == 0 {
+			s += strings.TrimSpace(kind + " " + b.Label) + "\n"
+		} else {
+			s += strings.TrimSpace("else " + b.Label) + "\n"
+		}
+		s += indent(b.Body)
+	}
+	return s + "end\n"
+}
+
+func (w *writer) participant(fn callgraph.Func) string {
+	if key := fn.ClassKey(); !w.seen[key] {
+		w.seen[key] = true
+		w.participants = append(w.participants, fn)
+	}
+	return puml.ClassAlias(fn.ClassKey())
+}
+
+// Messages of the calls in node in the order they run: arguments before the
+// call they are passed to. Function literals are left out as they run later,
+// if at all.
+func (w *writer) calls(from callgraph.Func, node ast.Node, level int) string {
+	if node == nil {
+		return ""
+	}
+	var s string
+	stack := make([]ast.Node, 0)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			top := stack[len(stack) - 1]
+			stack = stack[:len(stack) - 1]
+			if call, ok := top.(*ast.CallExpr); ok {
+				s += w.call(from, call, level, "->")
+			}
+			return false
+		}
+		if _, ok := n.(*ast.FuncLit); ok {
+			return false
+		}
+		stack = append(stack, n)
+		return true
+	})
+	return s
+}
+
+// Messages of the arguments of a go or defer statement's call, which run at
+// the statement, and of the call itself with arrow.
+func (w *writer) statementCall(from callgraph.Func, call *ast.CallExpr, level int, arrow string) (string, string) {
+	var args string
+	for _, arg := range call.Args {
+		args += w.calls(from, arg, level)
+	}
+	args += w.calls(from, call.Fun, level)
+	return args, w.call(from, call, level, arrow)
+}
+
+// Message of a call to a parsed function. Calls through an interface with
+// more than one implementation are an alt fragment of the implementations.
+func (w *writer) call(from callgraph.Func, call *ast.CallExpr, level int, arrow string) string {
+	callees, dynamic := w.g.Callees(call)
+	if len(callees) == 0 {
+		return ""
+	}
+
+	args := make([]string, 0)
+	for _, arg := range call.Args {
+		args = append(args, w.text(arg))
+	}
+	label := callees[0].Name + "(" + strings.Join(args, ", ") + ")"
+
+	if !dynamic || len(callees) == 1 {
+		return w.message(from, callees[0], label, level, arrow)
+	}
+	branches := make([]branch, 0)
+	for _, to := range callees {
+		branches = append(branches, branch{Label: to.Class, Body: w.message(from, to, label, level, arrow)})
+	}
+	return fragment("alt", branches)
+}
+
+// Message from from to to, followed by the calls of to while within --depth.
+// Asynchronous messages of go statements have no return.
+func (w *writer) message(from, to callgraph.Func, label string, level int, arrow string) string {
+	s := fmt.Sprintf(MESSAGE, w.participant(from), arrow, w.participant(to), label)
+	decl, exists := w.g.Decls[to.String()]
+	if level >= w.depth || w.stack[to.String()] || !exists || decl.Body == nil {
+		return s
+	}
+
+	id := w.participant(to)
+	s += "activate " + id + "\n"
+	s += indent(w.function(to, level + 1))
+	if arrow == "->" {
+		s += fmt.Sprintf(RETURN, id, w.participant(from))
+	}
+	return s + "deactivate " + id + "\n"
+}
+
+// Messages of the body of fn, whose calls are at level.
+func (w *writer) function(fn callgraph.Func, level int) string {
+	w.stack[fn.String()] = true
+	w.defers = append(w.defers, nil)
+	s := w.block(fn, w.g.Decls[fn.String()].Body.List, level)
+
+	defers := w.defers[len(w.defers) - 1]
+	for i := len(defers) - 1; i >= 0; i-- {
+		s += defers[i]
+	}
+	w.defers = w.defers[:len(w.defers) - 1]
+	delete(w.stack, fn.String())
+	return s
+}
+
+func (w *writer) block(from callgraph.Func, stmts []ast.Stmt, level int) string {
+	var s string
+	for _, stmt := range stmts {
+		s += w.stmt(from, stmt, level)
+	}
+	return s
+}
+
+// Messages of a statement. if and switch statements are alt fragments, for
+// loops loop fragments and select statements par fragments.
+func (w *writer) stmt(from callgraph.Func, stmt ast.Stmt, level int) string {
+	switch s := stmt.(type) {
+	case nil:
+		return ""
+	case *ast.BlockStmt:
+		return w.block(from, s.List, level)
+	case *ast.LabeledStmt:
+		return w.stmt(from, s.Stmt, level)
+	case *ast.IfStmt:
+		return w.stmt(from, s.Init, level) + w.calls(from, s.Cond, level) + fragment("alt", w.ifBranches(from, s, level))
+	case *ast.ForStmt:
+		// The condition runs before each iteration.
+		body := w.calls(from, s.Cond, level) + w.block(from, s.Body.List, level) + w.stmt(from, s.Post, level)
+		var label string
+		if s.Cond != nil {
+			label = w.text(s.Cond)
+		}
+		return w.stmt(from, s.Init, level) + fragment("loop", []branch{{label, body}})
+	case *ast.RangeStmt:
+		body := w.block(from, s.Body.List, level)
+		return w.calls(from, s.X, level) + fragment("loop", []branch{{"range " + w.text(s.X), body}})
+	case *ast.SwitchStmt:
+		branches := make([]branch, 0)
+		for _, clause := range s.Body.List {
+			c := clause.(*ast.CaseClause)
+			var body string
+			for _, e := range c.List {
+				body += w.calls(from, e, level)
+			}
+			branches = append(branches, branch{w.caseLabel(c.List), body + w.block(from, c.Body, level)})
+		}
+		return w.stmt(from, s.Init, level) + w.calls(from, s.Tag, level) + fragment("alt", branches)
+	case *ast.TypeSwitchStmt:
+		branches := make([]branch, 0)
+		for _, clause := range s.Body.List {
+			c := clause.(*ast.CaseClause)
+			branches = append(branches, branch{w.caseLabel(c.List), w.block(from, c.Body, level)})
+		}
+		return w.stmt(from, s.Init, level) + w.stmt(from, s.Assign, level) + fragment("alt", branches)
+	case *ast.SelectStmt:
+		branches := make([]branch, 0)
+		for _, clause := range s.Body.List {
+			c := clause.(*ast.CommClause)
+			label := "default"
+			if c.Comm != nil {
+				label = "case " + w.text(c.Comm)
+			}
+			branches = append(branches, branch{label, w.stmt(from, c.Comm, level) + w.block(from, c.Body, level)})
+		}
+		return fragment("par", branches)
+	case *ast.GoStmt:
+		args, call := w.statementCall(from, s.Call, level, "->>")
+		return args + call
+	case *ast.DeferStmt:
+		args, call := w.statementCall(from, s.Call, level, "->")
+		w.defers[len(w.defers) - 1] = append(w.defers[len(w.defers) - 1], call)
+		return args
+	default:
+		return w.calls(from, stmt, level)
+	}
+}
+
+// Branches of an if statement and its else if chain.
+func (w *writer) ifBranches(from callgraph.Func, s *ast.IfStmt, level int) []branch {
+	branches := []branch{{w.text(s.Cond), w.block(from, s.Body.List, level)}}
+	switch e := s.Else.(type) {
+	case *ast.IfStmt:
+		// Calls of the else if condition only run when the first is false.
+		next := w.ifBranches(from, e, level)
+		next[0].Body = w.stmt(from, e.Init, level) + w.calls(from, e.Cond, level) + next[0].Body
+		branches = append(branches, next...)
+	case *ast.BlockStmt:
+		branches = append(branches, branch{"", w.block(from, e.List, level)})
+	}
+	return branches
+}
+
+func (w *writer) caseLabel(list []ast.Expr) string {
+	if len(list) == 0 {
+		return "default"
+	}
+	cases := make([]string, 0)
+	for _, e := range list {
+		cases = append(cases, w.text(e))
+	}
+	return "case " + strings.Join(cases, ", ")
+}
+
+// Function of the call graph named by entry: "pkg.Func" for package functions
+// or "pkg.Type.Method".
+func Entry(g *callgraph.Graph, entry string) (callgraph.Func, error) {
+	if fn, exists := g.Funcs[entry]; exists {
+		return fn, nil
+	}
+	for _, fn := range g.Funcs {
+		if fn.Class == parser.GlobalName(fn.Namespace) && fn.Namespace + "." + fn.Name == entry {
+			return fn, nil
+		}
+	}
+	return callgraph.Func{}, fmt.Errorf("Couldn't find function: %s", entry)
+}
+
+// Sequence diagram of the calls made by entry, following calls to parsed
+// functions depth levels down. Participants are the classes of the functions,
+// <Pkg>Global for package functions, in the order they are first called.
+func SequenceString(g *callgraph.Graph, entry callgraph.Func, depth int) string {
+	w := &writer{g: g, depth: depth, seen: make(map[string]bool), stack: make(map[string]bool)}
+	id := w.participant(entry)
+	body := fmt.Sprintf("[-> %s : %s()\n", id, entry.Name)
+	body += "activate " + id + "\n"
+	body += indent(w.function(entry, 1))
+	body += "deactivate " + id + "\n"
+
+	// Classes of the same name in different packages are told apart by their
+	// package.
+	names := make(map[string]int)
+	for _, fn := range w.participants {
+		names[fn.Class]++
+	}
+	s := "@startuml\n" + style.Header()
+	for _, fn := range w.participants {
+		name := fn.Class
+		if names[name] > 1 {
+			name = fn.ClassKey()
+		}
+		s += fmt.Sprintf(PARTICIPANT, name, puml.ClassAlias(fn.ClassKey()))
+	}
+	return s + body + "@enduml"
+}
+
+func RenderSequence(g *callgraph.Graph, entry string) error {
+	fn, err := Entry(g, entry)
+	if err != nil {
+		return err
+	}
+	return plantuml.Output("sequence", SequenceString(g, fn, util.Depth))
+}
@@ -0,0 +1,147 @@
+package sequence
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"../callgraph"
+	"../parser"
+)
+
+// Call graph of the callgraph test packages: interface dispatch, defer, go,
+// for, if/else and select.
+func build(t *testing.T) *callgraph.Graph {
+	sources := make([]string, 0)
+	err := filepath.Walk("../callgraph/testdata/calls/src", func(path string, info os.FileInfo, err error) error {
+		if err == nil && filepath.Ext(path) == ".go" {
+			sources = append(sources, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := parser.Parser(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callgraph.Build(p)
+}
+
+func TestEntry(t *testing.T) {
+	g := build(t)
+	tests := []struct {
+		entry, want string
+	}{
+		{"app.Server.Run", "app.Server.Run"},
+		{"app.Start", "app.AppGlobal.Start"},
+		{"app.AppGlobal.Start", "app.AppGlobal.Start"},
+		{"shape.Total", "shape.ShapeGlobal.Total"},
+	}
+	for _, test := range tests {
+		fn, err := Entry(g, test.entry)
+		if err != nil {
+			t.Errorf("Entry(%q): %v", test.entry, err)
+			continue
+		}
+		if fn.String() != test.want {
+			t.Errorf("Entry(%q) = %s, want %s", test.entry, fn, test.want)
+		}
+	}
+
+	for _, entry := range []string{"app.Run", "app.Server.Stop", "shape.Square"} {
+		if _, err := Entry(g, entry); err == nil {
+			t.Errorf("Entry(%q) didn't fail", entry)
+		}
+	}
+}
+
+func TestFragment(t *testing.T) {
+	tests := []struct {
+		kind     string
+		branches []branch
+		want     string
+	}{
+		{"alt", []branch{{"a > 0", ""}, {"", ""}}, ""},
+		{"alt", []branch{{"a > 0", "x\n"}, {"", "y\n"}}, "alt a > 0\n\tx\nelse\n\ty\nend\n"},
+		{"loop", []branch{{"", "x\n"}}, "loop\n\tx\nend\n"},
+		{"par", []branch{{"case <-ch", "x\n"}, {"default", ""}}, "par case <-ch\n\tx\nelse default\nend\n"},
+	}
+	for _, test := range tests {
+		if got := fragment(test.kind, test.branches); got != test.want {
+			t.Errorf("fragment(%q, %v) = %q, want %q", test.kind, test.branches, got, test.want)
+		}
+	}
+}
+
+// if, for and select map to alt, loop and par fragments, the go statement to
+// an asynchronous ->> message and the deferred call comes last. The interface
+// call in Total is an alt of both implementations.
+func TestSequenceString(t *testing.T) {
+	g := build(t)
+	fn, err := Entry(g, "app.Server.Run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `@startuml
+participant "Server" as app_2e_Server
+participant "ShapeGlobal" as shape_2e_ShapeGlobal
+participant "Circle" as shape_2e_Circle
+participant "Square" as shape_2e_Square
+[-> app_2e_Server : Run()
+activate app_2e_Server
+	app_2e_Server ->> app_2e_Server : worker(ch)
+	activate app_2e_Server
+	deactivate app_2e_Server
+	loop i < 3
+		app_2e_Server -> app_2e_Server : step(i)
+		activate app_2e_Server
+		app_2e_Server --> app_2e_Server
+		deactivate app_2e_Server
+	end
+	alt len(s.Shapes) > 0
+		app_2e_Server -> shape_2e_ShapeGlobal : Total(s.Shapes)
+		activate shape_2e_ShapeGlobal
+			loop range shapes
+				alt Circle
+					shape_2e_ShapeGlobal -> shape_2e_Circle : Area()
+				else Square
+					shape_2e_ShapeGlobal -> shape_2e_Square : Area()
+				end
+			end
+		shape_2e_ShapeGlobal --> app_2e_Server
+		deactivate shape_2e_ShapeGlobal
+	else
+		app_2e_Server -> app_2e_Server : step(0)
+		activate app_2e_Server
+		app_2e_Server --> app_2e_Server
+		deactivate app_2e_Server
+	end
+	par case v := <-ch
+		app_2e_Server -> app_2e_Server : step(v)
+		activate app_2e_Server
+		app_2e_Server --> app_2e_Server
+		deactivate app_2e_Server
+	else default
+	end
+	app_2e_Server -> app_2e_Server : close()
+	activate app_2e_Server
+	app_2e_Server --> app_2e_Server
+	deactivate app_2e_Server
+deactivate app_2e_Server
+@enduml`
+	if got := SequenceString(g, fn, 2); got != want {
+		t.Errorf("SequenceString =\n%s\nwant\n%s", got, want)
+	}
+
+	// At depth 1 the calls of Total aren't followed.
+	got := SequenceString(g, fn, 1)
+	for _, participant := range []string{"shape_2e_Circle", "shape_2e_Square"} {
+		if strings.Contains(got, participant) {
+			t.Errorf("depth 1 has %s:\n%s", participant, got)
+		}
+	}
+}
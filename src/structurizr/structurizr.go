@@ -0,0 +1,183 @@
+package structurizr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"../c4"
+	"../puml"
+	"../util"
+)
+
+const (
+	BEGIN = "// globalpuml:begin "
+	END = "// globalpuml:end"
+	INDENT = "    "
+	CONTAINER = "%s = container \"%s\" \"Go module\" {\n"
+	COMPONENT = "%s = component \"%s\" \"%s\" \"Go package\"\n"
+	REL = "%s -> %s \"Uses\"\n"
+	WEIGHTED_REL = "%s -> %s \"Uses (%s)\"\n"
+	VIEW = "component %s {\n"
+)
+
+// Structurizr identifiers may only hold letters, digits, underscores and
+// dashes, so the collision free PlantUML ids are reused.
+func id(name string) string {
+	return puml.PackageAlias(name)
+}
+
+func moduleID(module string) string {
+	return "module_" + id(module)
+}
+
+// Namespaces grouped by module, both sorted.
+func modules(uml *puml.PlantUML) ([]string, map[string][]puml.Namespace) {
+	modules := make(map[string][]puml.Namespace)
+	for _, ns := range uml.SortedNamespaces() {
+		modules[ns.Module] = append(modules[ns.Module], ns)
+	}
+
+	names := make([]string, 0)
+	for name, _ := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, modules
+}
+
+func indent(lines, prefix string) string {
+	var s string
+	for _, line := range strings.SplitAfter(lines, "\n") {
+		if line != "" {
+			s += prefix + line
+		}
+	}
+	return s
+}
+
+// Containers, components and relationships of the software system.
+func ModelString(uml *puml.PlantUML) string {
+	var dsl string
+	names, modules := modules(uml)
+	for _, name := range names {
+		dsl += fmt.Sprintf(CONTAINER, moduleID(name), name)
+		for _, ns := range modules[name] {
+			dsl += INDENT + fmt.Sprintf(COMPONENT, id(ns.Name), ns.Name, c4.Description(ns.Doc))
+		}
+		dsl += "}\n"
+	}
+
+	for _, ns := range uml.SortedNamespaces() {
+		deps := make([]string, 0)
+		for dep, _ := range ns.Dependencies {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+
+		for _, dep := range deps {
+			if _, exists := uml.Namespaces[dep]; !exists {
+				continue
+			}
+			if n := ns.Dependencies[dep]; n != 0 {
+				dsl += fmt.Sprintf(WEIGHTED_REL, id(ns.Name), id(dep), util.References(n))
+			} else {
+				dsl += fmt.Sprintf(REL, id(ns.Name), id(dep))
+			}
+		}
+	}
+	return dsl
+}
+
+// One component view per container.
+func ViewsString(uml *puml.PlantUML) string {
+	var dsl string
+	names, _ := modules(uml)
+	for _, name := range names {
+		dsl += fmt.Sprintf(VIEW, moduleID(name))
+		dsl += INDENT + "include *\n"
+		dsl += INDENT + "autoLayout lr\n"
+		dsl += "}\n"
+	}
+	return dsl
+}
+
+// Generated sections by marker name.
+func sections(uml *puml.PlantUML) map[string]string {
+	return map[string]string{
+		"model": ModelString(uml),
+		"views": ViewsString(uml),
+	}
+}
+
+func WorkspaceString(uml *puml.PlantUML) string {
+	system := util.System
+	if system == "" {
+		system = "Software System"
+	}
+
+	in := INDENT + INDENT + INDENT
+	dsl := "workspace {\n"
+	dsl += INDENT + "model {\n"
+	dsl += INDENT + INDENT + fmt.Sprintf("system = softwareSystem \"%s\" {\n", system)
+	dsl += in + BEGIN + "model\n"
+	dsl += indent(ModelString(uml), in)
+	dsl += in + END + "\n"
+	dsl += INDENT + INDENT + "}\n"
+	dsl += INDENT + "}\n"
+	dsl += INDENT + "views {\n"
+	dsl += INDENT + INDENT + BEGIN + "views\n"
+	dsl += indent(ViewsString(uml), INDENT + INDENT)
+	dsl += INDENT + INDENT + END + "\n"
+	dsl += INDENT + "}\n"
+	return dsl + "}\n"
+}
+
+// Replace the generated sections of an existing workspace. Everything
+// outside the begin and end markers is left as is.
+func Merge(dsl string, uml *puml.PlantUML) (string, error) {
+	generated := sections(uml)
+	var merged, section string
+	for i, line := range strings.SplitAfter(dsl, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case section != "" && trimmed == END:
+			section = ""
+		case section != "":
+			continue
+		case strings.HasPrefix(trimmed, BEGIN):
+			section = strings.TrimSpace(strings.TrimPrefix(trimmed, BEGIN))
+			content, exists := generated[section]
+			if !exists {
+				return "", fmt.Errorf("Unknown generated section on line %d: %s", i + 1, section)
+			}
+			merged += line + indent(content, line[:strings.Index(line, "//")])
+			continue
+		}
+		merged += line
+	}
+
+	if section != "" {
+		return "", fmt.Errorf("Generated section %s has no end marker", section)
+	}
+	return merged, nil
+}
+
+func RenderStructurizr(uml *puml.PlantUML) error {
+	if util.Merge == "" {
+		fmt.Print(WorkspaceString(uml))
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(util.Merge)
+	if err != nil {
+		return err
+	}
+
+	merged, err := Merge(string(data), uml)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(util.Merge, []byte(merged), 0644)
+}
@@ -0,0 +1,212 @@
+package style
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Config file read when --config isn't given, if it exists.
+const CONFIG = "globalpuml.json"
+
+// Spot of a class kind, eg. the green "G" of <Pkg>Global objects.
+type Stereotype struct {
+	Letter			string				`json:"letter"`
+	Color			string				`json:"color"`
+}
+
+// PlantUML styling. Stereotypes are by class kind: "global", "struct" and
+// "type". Keywords is the color of type keywords such as "map" and "func",
+// empty for none.
+type Style struct {
+	Theme			string					`json:"theme"`
+	Skinparams		map[string]string		`json:"skinparams"`
+	Stereotypes		map[string]Stereotype	`json:"stereotypes"`
+	Keywords		string					`json:"keywords"`
+}
+
+// Style of a config file. Fields are pointers so that fields left out are
+// told apart from fields set empty, eg. "keywords": "" for no color.
+type override struct {
+	Theme			*string							`json:"theme"`
+	Skinparams		map[string]string				`json:"skinparams"`
+	Stereotypes		map[string]stereotypeOverride	`json:"stereotypes"`
+	Keywords		*string							`json:"keywords"`
+}
+
+type stereotypeOverride struct {
+	Letter			*string				`json:"letter"`
+	Color			*string				`json:"color"`
+}
+
+type Config struct {
+	// Preset the style starts from, overridden by --style.
+	Preset			string				`json:"preset"`
+	Style			json.RawMessage		`json:"style"`
+}
+
+var Presets = map[string]Style{
+	"default": Style{
+		Stereotypes: map[string]Stereotype{
+			"global": Stereotype{"G", "Green"},
+			"struct": Stereotype{"S", "Aquamarine"},
+			"type": Stereotype{"T", "#FF7700"},
+		},
+		Keywords: "blue",
+	},
+	"dark": Style{
+		Theme: "cyborg",
+		Stereotypes: map[string]Stereotype{
+			"global": Stereotype{"G", "#2E7D32"},
+			"struct": Stereotype{"S", "#00838F"},
+			"type": Stereotype{"T", "#EF6C00"},
+		},
+		Keywords: "#79C0FF",
+	},
+	"plain": Style{
+		Theme: "plain",
+		Stereotypes: map[string]Stereotype{
+			"global": Stereotype{"G", "#DDDDDD"},
+			"struct": Stereotype{"S", "#DDDDDD"},
+			"type": Stereotype{"T", "#DDDDDD"},
+		},
+	},
+	"mono": Style{
+		Theme: "mono",
+		Skinparams: map[string]string{
+			"monochrome": "true",
+		},
+		Stereotypes: map[string]Stereotype{
+			"global": Stereotype{"G", "White"},
+			"struct": Stereotype{"S", "White"},
+			"type": Stereotype{"T", "White"},
+		},
+	},
+}
+
+// Style of the diagrams being written.
+var Current = Presets["default"].copy()
+
+func (s Style) copy() Style {
+	c := s
+	c.Skinparams = make(map[string]string)
+	for k, v := range s.Skinparams {
+		c.Skinparams[k] = v
+	}
+	c.Stereotypes = make(map[string]Stereotype)
+	for k, v := range s.Stereotypes {
+		c.Stereotypes[k] = v
+	}
+	return c
+}
+
+// Set Current from the preset, or the preset of the config file, then the
+// style of the config file on top. Fields left out of the config file keep the
+// preset's values.
+func Load(path, preset string) error {
+	var config Config
+	if path == "" {
+		if _, err := os.Stat(CONFIG); err == nil {
+			path = CONFIG
+		}
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if preset == "" {
+		preset = config.Preset
+	}
+	if preset == "" {
+		preset = "default"
+	}
+	s, exists := Presets[preset]
+	if !exists {
+		return fmt.Errorf("Unknown style: %s", preset)
+	}
+	s = s.copy()
+
+	if len(config.Style) != 0 {
+		var o override
+		if err := json.Unmarshal(config.Style, &o); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		s.merge(o)
+	}
+	Current = s
+	return nil
+}
+
+// Set the fields of o on s, field by field.
+func (s *Style) merge(o override) {
+	if o.Theme != nil {
+		s.Theme = *o.Theme
+	}
+	for k, v := range o.Skinparams {
+		s.Skinparams[k] = v
+	}
+	for k, v := range o.Stereotypes {
+		stereotype := s.Stereotypes[k]
+		if v.Letter != nil {
+			stereotype.Letter = *v.Letter
+		}
+		if v.Color != nil {
+			stereotype.Color = *v.Color
+		}
+		s.Stereotypes[k] = stereotype
+	}
+	if o.Keywords != nil {
+		s.Keywords = *o.Keywords
+	}
+}
+
+// "!theme" and "skinparam" lines that go after "@startuml".
+func Header() string {
+	var header string
+	if Current.Theme != "" {
+		header += "!theme " + Current.Theme + "\n"
+	}
+
+	keys := make([]string, 0)
+	for k, _ := range Current.Skinparams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		header += fmt.Sprintf("skinparam %s %s\n", k, Current.Skinparams[k])
+	}
+	return header
+}
+
+// Spot of the class kind, eg. "<< (G,Green) >>".
+func Spot(kind string) string {
+	s, exists := Current.Stereotypes[kind]
+	if !exists || s.Letter == "" {
+		return ""
+	}
+	color := s.Color
+	if color == "" {
+		color = "White"
+	}
+	return fmt.Sprintf("<< (%s,%s) >>", s.Letter, color)
+}
+
+// Type keywords in the Keywords color.
+func Keywords(text string) string {
+	if Current.Keywords == "" {
+		return text
+	}
+	for _, keyword := range []string{"map", "struct", "chan", "func"} {
+		text = strings.Replace(text, keyword, fmt.Sprintf("<font color=%s>%s</font>", Current.Keywords, keyword), -1)
+	}
+	return text
+}
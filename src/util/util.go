@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os"
 	"fmt"
+	"time"
 	"strings"
 	//"unicode"
 	"regexp"
@@ -12,6 +13,66 @@ import (
 
 var Debug, Global bool
 
+// Method signature style: "full" or "short".
+var Signatures string = "full"
+
+// Output format. Empty picks the default of the command.
+var Format string
+
+// Members shown: "all" or "exported" for a public API view.
+var Visibility string = "all"
+
+// Only draw classes within Depth hops of the Focus class ("pkg.Type"),
+// following relationships "in", "out" or "both" ways.
+var Focus string
+var Depth int = 1
+var Direction string = "both"
+
+// Diagram level: "class" or "package".
+var Level string = "class"
+
+// Graphviz layout: rankdir and whether parallel edges are merged.
+var Rankdir string = "TB"
+var Concentrate bool
+
+// PlantUML layout: hide empty members, group related classes in together
+// blocks, and order packages by dependency depth.
+var HideEmpty bool
+var Together bool
+var Layering bool
+
+// Drop the leading path segments all packages share from PlantUML package
+// names.
+var CollapsePrefix bool
+
+// Type check the sources and draw the calls between classes.
+var Calls bool
+
+// Structurizr software system name, and the workspace file to merge the
+// generated sections into.
+var System string
+var Merge string
+
+// Directory the formats writing several files write to.
+var Out string = "."
+
+// Style preset and the config file with the style on top of it.
+var Style string
+var Config string
+
+// "package" writes one PlantUML diagram per package and an index diagram.
+var Split string
+
+// Image format PlantUML diagrams are rendered to, "svg" or "png", with a local
+// plantuml.jar or a PlantUML server.
+var Render string
+var PlantUML string
+var Server string
+var Timeout time.Duration = time.Minute
+
+// Highlight mutable package state on the <Pkg>Global objects.
+var Audit bool
+
 func PrintErr(err error) {
 	fmt.Fprintf(os.Stderr, err.Error() + "\n")
 }
@@ -54,4 +115,11 @@ func Dump(v interface{}) ([]byte, error) {
 		return nil, err
 	}
 	return buffer.Bytes(), nil
-}
\ No newline at end of file
+}
+// "1 reference" or "n references".
+func References(n int) string {
+	if n == 1 {
+		return "1 reference"
+	}
+	return fmt.Sprintf("%d references", n)
+}
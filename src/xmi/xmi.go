@@ -0,0 +1,495 @@
+package xmi
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"../model"
+	"../parser"
+	"../puml"
+)
+
+const (
+	UML = "http://www.omg.org/spec/UML/20161101"
+	HEADER = `<?xml version="1.0" encoding="UTF-8"?>
+<xmi:XMI xmi:version="2.5.1" xmlns:xmi="http://www.omg.org/spec/XMI/20131001" xmlns:uml="` + UML + `" xmlns:StandardProfile="` + UML + `/StandardProfile">
+	<uml:Model xmi:type="uml:Model" xmi:id="model" name="GlobalPUML">
+		<packageImport xmi:type="uml:PackageImport" xmi:id="model-primitives">
+			<importedPackage href="` + UML + `/PrimitiveTypes.xmi#_0"/>
+		</packageImport>
+		<profileApplication xmi:type="uml:ProfileApplication" xmi:id="model-profile">
+			<appliedProfile href="` + UML + `/StandardProfile.xmi#_0"/>
+		</profileApplication>
+`
+	MODEL_END = "\t</uml:Model>\n"
+	UTILITY = "\t<StandardProfile:Utility xmi:id=\"%s\" base_Class=\"%s\"/>\n"
+	FOOTER = "</xmi:XMI>\n"
+	PACKAGE = "%s<packagedElement xmi:type=\"uml:Package\" xmi:id=\"%s\" name=\"%s\">\n"
+	CLASSIFIER = "%s<packagedElement xmi:type=\"uml:%s\" xmi:id=\"%s\" name=\"%s\"%s>\n"
+	COMMENT = "%s<ownedComment xmi:type=\"uml:Comment\" xmi:id=\"%s\" body=\"%s\"/>\n"
+	LITERAL = "%s<ownedLiteral xmi:type=\"uml:EnumerationLiteral\" xmi:id=\"%s\" name=\"%s\"/>\n"
+	PROPERTY = "%s<ownedAttribute xmi:type=\"uml:Property\" xmi:id=\"%s\" name=\"%s\" visibility=\"%s\"%s"
+	OPERATION = "%s<ownedOperation xmi:type=\"uml:Operation\" xmi:id=\"%s\" name=\"%s\" visibility=\"%s\"%s>\n"
+	PARAMETER = "%s<ownedParameter xmi:type=\"uml:Parameter\" xmi:id=\"%s\" name=\"%s\" direction=\"%s\"%s"
+	GENERALIZATION = "%s<generalization xmi:type=\"uml:Generalization\" xmi:id=\"%s\" general=\"%s\"/>\n"
+	REALIZATION = "%s<interfaceRealization xmi:type=\"uml:InterfaceRealization\" xmi:id=\"%s\" client=\"%s\" supplier=\"%s\" contract=\"%s\" implementingClassifier=\"%s\"/>\n"
+	DEPENDENCY = "%s<packagedElement xmi:type=\"uml:Dependency\" xmi:id=\"%s\" client=\"%s\" supplier=\"%s\"/>\n"
+	ASSOCIATION = "%s<packagedElement xmi:type=\"uml:Association\" xmi:id=\"%s\" memberEnd=\"%s %s\">\n"
+	END = "%s<ownedEnd xmi:type=\"uml:Property\" xmi:id=\"%s\" type=\"%s\" association=\"%s\"/>\n"
+	PRIMITIVE = "%s<type href=\"" + UML + "/PrimitiveTypes.xmi#%s\"/>\n"
+	UNLIMITED = "%s<upperValue xmi:type=\"uml:LiteralUnlimitedNatural\" xmi:id=\"%s\" value=\"*\"/>\n"
+	DATA_TYPE = "\t\t\t<packagedElement xmi:type=\"uml:DataType\" xmi:id=\"%s\" name=\"%s\"/>\n"
+)
+
+// Go basic types by UML primitive type.
+var primitives = map[string]string{
+	"string": "String",
+	"bool": "Boolean",
+	"float32": "Real",
+	"float64": "Real",
+}
+
+func init() {
+	for _, typ := range []string{"int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte", "rune"} {
+		primitives[typ] = "Integer"
+	}
+}
+
+func escape(s string) string {
+	buffer := &bytes.Buffer{}
+	xml.EscapeText(buffer, []byte(s))
+	return buffer.String()
+}
+
+// xmi:id values are XML names, so package paths and other characters are
+// replaced. Parts are joined with "-", which Go names can't hold, and may be
+// ids themselves.
+func id(parts ...string) string {
+	re := regexp.MustCompile("[^A-Za-z\\d_.-]")
+	s := re.ReplaceAllString(strings.Join(parts, "-"), "_")
+	if !strings.HasPrefix(s, "_") {
+		s = "_" + s
+	}
+	return s
+}
+
+type exporter struct {
+	uml				*puml.PlantUML
+	// Enumeration literals of "namespace.Type" keys, left out of the
+	// <Pkg>Global object.
+	literals		map[string][]string
+	enumerated		parser.Set
+	// Types that aren't classes of the model nor primitives, by id.
+	dataTypes		map[string]string
+}
+
+func exported(name string) bool {
+	return strings.ToUpper(name[:1]) == name[:1]
+}
+
+func visibility(name string) string {
+	if exported(name) {
+		return "public"
+	}
+	return "package"
+}
+
+// Key of the class behind typ as used from namespace, or "" when it isn't a
+// class of the model.
+func (e *exporter) resolve(namespace, typ string) string {
+	name := parser.EmbeddedName(typ)
+	if name == "" {
+		return ""
+	}
+	if i := strings.Index(typ, "["); i >= 0 {
+		typ = typ[:i]
+	}
+	if i := strings.Index(typ, "."); i < 0 {
+		if key := namespace + "." + name; e.uml.HasClass(key) {
+			return key
+		}
+		return ""
+	}
+
+	pkg := strings.TrimPrefix(typ[:strings.Index(typ, ".")], "*")
+	for _, ns := range e.uml.SortedNamespaces() {
+		split := strings.Split(ns.Name, "/")
+		if split[len(split)-1] != pkg {
+			continue
+		}
+		if _, exists := ns.Classes[name]; exists {
+			return ns.Name + "." + name
+		}
+	}
+	return ""
+}
+
+// Type of a property or parameter, with an unlimited upper bound for slices
+// and variadic parameters.
+func (e *exporter) typeString(namespace, typ, owner, prefix string) (string, string) {
+	many := false
+	for {
+		switch {
+		case strings.HasPrefix(typ, "[]") || strings.HasPrefix(typ, "..."):
+			many = true
+			typ = strings.TrimLeft(typ, "[].")
+			continue
+		case strings.HasPrefix(typ, "*"):
+			typ = typ[1:]
+			continue
+		}
+		break
+	}
+
+	var attr, body string
+	switch {
+	case typ == "":
+	case primitives[typ] != "":
+		body = fmt.Sprintf(PRIMITIVE, prefix, primitives[typ])
+	case e.resolve(namespace, typ) != "":
+		attr = fmt.Sprintf(" type=\"%s\"", id(e.resolve(namespace, typ)))
+	default:
+		key := id("types", typ)
+		for n := 2; e.dataTypes[key] != "" && e.dataTypes[key] != typ; n++ {
+			key = id("types", typ, fmt.Sprint(n))
+		}
+		e.dataTypes[key] = typ
+		attr = fmt.Sprintf(" type=\"%s\"", key)
+	}
+	if many {
+		body += fmt.Sprintf(UNLIMITED, prefix, id(owner, "upper"))
+	}
+	return attr, body
+}
+
+// Element started by open, empty when it has no body.
+func element(open, body, prefix, tag string) string {
+	if body == "" {
+		return open + "/>\n"
+	}
+	return open + ">\n" + body + prefix + "</" + tag + ">\n"
+}
+
+func comment(prefix, owner, doc string) string {
+	if doc == "" {
+		return ""
+	}
+	return fmt.Sprintf(COMMENT, prefix, id(owner, "doc"), escape(doc))
+}
+
+// Consts typed with a type of the package are its enumeration literals.
+// Untyped consts take the type of the const above, as in iota blocks, as long
+// as only their doc comment is in between.
+func (e *exporter) enumerations(ns puml.Namespace) {
+	var global puml.Class
+	for _, c := range ns.Classes {
+		if c.Type == "global" {
+			global = c
+		}
+	}
+
+	consts := make([]string, 0)
+	for name, _ := range global.Consts {
+		if global.VarPositions[name].Line != 0 {
+			consts = append(consts, name)
+		}
+	}
+	sort.Slice(consts, func(i, j int) bool {
+		a, b := global.VarPositions[consts[i]], global.VarPositions[consts[j]]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+
+	var typ string
+	var last parser.Position
+	for _, name := range consts {
+		pos := global.VarPositions[name]
+		declared := global.PublicVars[name] + global.PrivateVars[name]
+		docLines := 0
+		if doc := global.VarDocs[name]; doc != "" {
+			docLines = strings.Count(doc, "\n") + 1
+		}
+
+		switch {
+		case declared != "":
+			typ = declared
+		case pos.File != last.File || pos.Line != last.Line + 1 + docLines:
+			typ = ""
+		}
+		last = pos
+
+		c, exists := ns.Classes[typ]
+		if typ == "" || !exists || model.Kind(c) != "type" {
+			continue
+		}
+		key := ns.Name + "." + typ
+		e.literals[key] = append(e.literals[key], name)
+		e.enumerated[ns.Name + "." + global.Name + "." + name] = struct{}{}
+	}
+}
+
+func (e *exporter) properties(namespace string, c puml.Class, prefix string) string {
+	key := namespace + "." + c.Name
+	names := make([]string, 0)
+	for _, vars := range []map[string]string{c.PrivateVars, c.PublicVars} {
+		for name, _ := range vars {
+			if _, exists := e.enumerated[key + "." + name]; !exists {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var x string
+	for _, name := range names {
+		owner := id(key, name)
+		var extra string
+		if c.Type == "global" {
+			extra = " isStatic=\"true\""
+			if _, isConst := c.Consts[name]; isConst {
+				extra += " isReadOnly=\"true\""
+			}
+		}
+		attr, body := e.typeString(namespace, c.PublicVars[name] + c.PrivateVars[name], owner, prefix + "\t")
+		body += comment(prefix + "\t", owner, c.VarDocs[name])
+		open := fmt.Sprintf(PROPERTY, prefix, owner, name, visibility(name), extra + attr)
+		x += element(open, body, prefix, "ownedAttribute")
+	}
+	return x
+}
+
+func (e *exporter) parameter(namespace string, p parser.Param, owner, direction, prefix string) string {
+	attr, body := e.typeString(namespace, p.Type, owner, prefix + "\t")
+	open := fmt.Sprintf(PARAMETER, prefix, owner, escape(p.Name), direction, attr)
+	return element(open, body, prefix, "ownedParameter")
+}
+
+func (e *exporter) operations(namespace string, c puml.Class, prefix string) string {
+	funcs := make([]parser.Func, 0)
+	for _, fs := range []map[string]parser.Func{c.PrivateFuncs, c.PublicFuncs} {
+		for _, fn := range fs {
+			funcs = append(funcs, fn)
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].Name < funcs[j].Name
+	})
+
+	var x string
+	for _, fn := range funcs {
+		owner := id(namespace + "." + c.Name, fn.Name, "op")
+		var extra string
+		if c.Type == "global" {
+			extra = " isStatic=\"true\""
+		} else if model.Kind(c) == "interface" {
+			extra = " isAbstract=\"true\""
+		}
+		x += fmt.Sprintf(OPERATION, prefix, owner, fn.Name, visibility(fn.Name), extra)
+		x += comment(prefix + "\t", owner, fn.Doc)
+		for i, p := range fn.Params {
+			x += e.parameter(namespace, p, id(owner, fmt.Sprint(i)), "in", prefix + "\t")
+		}
+		// UML has a single return parameter, extra results are out parameters.
+		for i, p := range fn.Results {
+			direction := "out"
+			if i == 0 {
+				direction = "return"
+			}
+			x += e.parameter(namespace, p, id(owner, "result", fmt.Sprint(i)), direction, prefix + "\t")
+		}
+		x += prefix + "</ownedOperation>\n"
+	}
+	return x
+}
+
+// Methods of the class key by name, with the number of params and results.
+// Includes the methods promoted from embedded classes of the model.
+func (e *exporter) methodSet(key string, seen parser.Set) map[string][2]int {
+	methods := make(map[string][2]int)
+	if _, exists := seen[key]; exists || !e.uml.HasClass(key) {
+		return methods
+	}
+	seen[key] = struct{}{}
+
+	i := strings.LastIndex(key, ".")
+	c := e.uml.Namespaces[key[:i]].Classes[key[i+1:]]
+	for _, embed := range c.Embeds {
+		for name, arity := range e.methodSet(e.resolve(key[:i], embed), seen) {
+			methods[name] = arity
+		}
+	}
+	for _, fs := range []map[string]parser.Func{c.PrivateFuncs, c.PublicFuncs} {
+		for name, fn := range fs {
+			methods[name] = [2]int{len(fn.Params), len(fn.Results)}
+		}
+	}
+	return methods
+}
+
+// Interfaces of the model c implements. Only the method names and arities are
+// compared as types are written relative to their own package.
+func (e *exporter) implements(key string, c puml.Class) []string {
+	keys := make([]string, 0)
+	if model.Kind(c) == "interface" || c.Type == "global" {
+		return keys
+	}
+	methods := e.methodSet(key, make(parser.Set))
+	for _, ns := range e.uml.SortedNamespaces() {
+		for _, i := range ns.SortedClasses() {
+			if model.Kind(i) != "interface" {
+				continue
+			}
+			contract := e.methodSet(ns.Name + "." + i.Name, make(parser.Set))
+			if len(contract) == 0 {
+				continue
+			}
+			implemented := true
+			for name, arity := range contract {
+				if m, exists := methods[name]; !exists || m != arity {
+					implemented = false
+					break
+				}
+			}
+			if implemented {
+				keys = append(keys, ns.Name + "." + i.Name)
+			}
+		}
+	}
+	return keys
+}
+
+func (e *exporter) classifier(namespace string, c puml.Class, prefix string) string {
+	key := namespace + "." + c.Name
+	kind := model.Kind(c)
+	typ := "Class"
+	switch {
+	case kind == "interface":
+		typ = "Interface"
+	case len(e.literals[key]) != 0:
+		typ = "Enumeration"
+	}
+
+	var extra string
+	if kind == "interface" {
+		extra = " isAbstract=\"true\""
+	}
+	x := fmt.Sprintf(CLASSIFIER, prefix, typ, id(key), escape(c.Name), extra)
+	in := prefix + "\t"
+	x += comment(in, key, c.Doc)
+
+	realized := make(parser.Set)
+	for _, embed := range c.Embeds {
+		general := e.resolve(namespace, embed)
+		if general == "" {
+			continue
+		}
+		ns := e.uml.Namespaces[general[:strings.LastIndex(general, ".")]]
+		if model.Kind(ns.Classes[general[strings.LastIndex(general, ".")+1:]]) == "interface" && kind != "interface" {
+			realized[general] = struct{}{}
+			continue
+		}
+		x += fmt.Sprintf(GENERALIZATION, in, id(key, "general", general), id(general))
+	}
+	for _, contract := range e.implements(key, c) {
+		realized[contract] = struct{}{}
+	}
+	contracts := make([]string, 0)
+	for contract, _ := range realized {
+		contracts = append(contracts, contract)
+	}
+	sort.Strings(contracts)
+	for _, contract := range contracts {
+		x += fmt.Sprintf(REALIZATION, in, id(key, "realizes", contract), id(key), id(contract), id(contract), id(key))
+	}
+
+	for _, literal := range e.literals[key] {
+		x += fmt.Sprintf(LITERAL, in, id(key, literal), literal)
+	}
+	if !c.Collapsed {
+		x += e.properties(namespace, c, in)
+		x += e.operations(namespace, c, in)
+	}
+	return x + prefix + "</packagedElement>\n"
+}
+
+// Relationships owned by the package of their source class.
+func (e *exporter) relationships(namespace, prefix string) string {
+	var x string
+	for _, edge := range e.uml.Edges() {
+		if edge.From[:strings.LastIndex(edge.From, ".")] != namespace {
+			continue
+		}
+		if !e.uml.HasClass(edge.From) || !e.uml.HasClass(edge.To) {
+			continue
+		}
+		if edge.Kind == puml.DEPENDENCY {
+			x += fmt.Sprintf(DEPENDENCY, prefix, id(edge.From, "uses", edge.To), id(edge.From), id(edge.To))
+			continue
+		}
+		if edge.Kind == puml.CALLS {
+			x += fmt.Sprintf(DEPENDENCY, prefix, id(edge.From, "calls", edge.To), id(edge.From), id(edge.To))
+			continue
+		}
+		association := id(edge.From, "association", edge.To)
+		from, to := id(association, "from"), id(association, "to")
+		x += fmt.Sprintf(ASSOCIATION, prefix, association, from, to)
+		x += fmt.Sprintf(END, prefix + "\t", from, id(edge.From), association)
+		x += fmt.Sprintf(END, prefix + "\t", to, id(edge.To), association)
+		x += prefix + "</packagedElement>\n"
+	}
+	return x
+}
+
+// XMI 2.5.1 model for UML tools such as Enterprise Architect or Papyrus. The
+// <Pkg>Global objects are classes with the «utility» stereotype.
+func XMIString(uml *puml.PlantUML) string {
+	e := exporter{uml: uml, literals: make(map[string][]string), enumerated: make(parser.Set), dataTypes: make(map[string]string)}
+	for _, ns := range uml.SortedNamespaces() {
+		e.enumerations(ns)
+	}
+
+	x := HEADER
+	utilities := make([]string, 0)
+	for _, ns := range uml.SortedNamespaces() {
+		x += fmt.Sprintf(PACKAGE, "\t\t", id(ns.Name), escape(ns.Name))
+		x += comment("\t\t\t", ns.Name, ns.Doc)
+		for _, c := range ns.SortedClasses() {
+			x += e.classifier(ns.Name, c, "\t\t\t")
+			if c.Type == "global" {
+				utilities = append(utilities, ns.Name + "." + c.Name)
+			}
+		}
+		x += e.relationships(ns.Name, "\t\t\t")
+		x += "\t\t</packagedElement>\n"
+	}
+
+	if len(e.dataTypes) != 0 {
+		keys := make([]string, 0)
+		for key, _ := range e.dataTypes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		x += fmt.Sprintf(PACKAGE, "\t\t", id("types"), "types")
+		for _, key := range keys {
+			x += fmt.Sprintf(DATA_TYPE, key, escape(e.dataTypes[key]))
+		}
+		x += "\t\t</packagedElement>\n"
+	}
+	x += MODEL_END
+
+	for _, key := range utilities {
+		x += fmt.Sprintf(UTILITY, id(key, "utility"), id(key))
+	}
+	return x + FOOTER
+}
+
+func RenderXMI(uml *puml.PlantUML) error {
+	fmt.Print(XMIString(uml))
+	return nil
+}